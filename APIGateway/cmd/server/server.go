@@ -7,8 +7,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// trendingPeriod is how often the trending news are recomputed.
+const trendingPeriod = time.Minute
+
 type server struct {
 	api *api.API
 }
@@ -28,6 +32,8 @@ func main() {
 	log.Printf("[*] HTTP APIGateway server is started on http://localhost%s", port)
 	log.SetOutput(file)
 
+	go srv.api.RefreshTrending(trendingPeriod)
+
 	go func() {
 		if err := http.ListenAndServe(port, srv.api.Router()); err != nil {
 			log.Fatalf("Could not listen on port %s: %v\n", port, err)
@@ -1,15 +1,25 @@
 package api
 
 import (
+	"APIGateway/pkg/auth"
+	"APIGateway/pkg/idempotency"
 	"APIGateway/pkg/models"
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 
 	"math/rand"
@@ -18,19 +28,58 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// cacheHeaders are the response headers of the news service
+// that let clients cache and revalidate responses.
+var cacheHeaders = []string{"ETag", "Last-Modified", "Cache-Control"}
+
+// Moderation statuses of comments stored by the comments service.
+const (
+	statusPending  = "pending"
+	statusApproved = "approved"
+)
+
+// conditionalHeaders are the request headers used by clients
+// to revalidate their cached responses.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
 const (
 	reqIDStr    string = "requset_id"
 	newsUrl     string = "http://localhost:8081/news"
+	tagsUrl     string = "http://localhost:8081/tags"
 	commentsUrl string = "http://localhost:8082/comments"
+	usersUrl    string = "http://localhost:8082/users"
 	cenzorUrl   string = "http://localhost:8083/cenzor"
 )
 
 type API struct {
-	r *mux.Router
+	r        *mux.Router
+	trending trendingCache
+	// jwtSecret signs the access tokens, principalSecret signs the principals, see authMiddleware.
+	jwtSecret       []byte
+	principalSecret []byte
+	// idempotency holds the responses to the requests with an idempotency key, see idempotent.
+	idempotency *idempotency.Store
 }
 
+// New creates the API. The secret of the access tokens is taken from the jwt_secret environment variable,
+// the secret of the principals passed to the comments service from the principal_secret environment variable.
+// The time the responses to the requests with an idempotency key are kept is taken
+// from the idempotency_ttl environment variable, e.g. "1h", and is idempotencyTTL by default;
+// the number of the keys held is limited by the idempotency_max_keys environment variable,
+// maxIdempotencyKeys by default.
 func New() *API {
 	api := &API{}
+	api.jwtSecret = []byte(os.Getenv("jwt_secret"))
+	api.principalSecret = []byte(os.Getenv("principal_secret"))
+	ttl, err := time.ParseDuration(os.Getenv("idempotency_ttl"))
+	if err != nil || ttl <= 0 {
+		ttl = idempotencyTTL
+	}
+	maxKeys, err := strconv.Atoi(os.Getenv("idempotency_max_keys"))
+	if err != nil || maxKeys <= 0 {
+		maxKeys = maxIdempotencyKeys
+	}
+	api.idempotency = idempotency.New(ttl, maxKeys)
 	api.r = mux.NewRouter()
 	api.endpoints()
 	return api
@@ -40,21 +89,49 @@ func (api *API) endpoints() {
 	api.r.Use(requestIDMiddleware)
 	api.r.Use(headersMiddleware)
 	api.r.Use(logMiddleware)
+	api.r.Use(api.authMiddleware)
+	api.r.HandleFunc("/auth/token", api.issueToken).Methods(http.MethodPost, http.MethodOptions)
 	api.r.HandleFunc("/news", api.news).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/news/filter", api.newsFilter).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/trending", api.trendingNews).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/news/id", api.detailedNews).Methods(http.MethodGet, http.MethodOptions)
-	api.r.HandleFunc("/news/comment", api.addComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/news/id/revisions", api.newsRevisions).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/id/related", api.newsRelated).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/batch", api.newsBatch).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comments", api.newsComments).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comment", api.comment).Methods(http.MethodGet)
+	api.r.HandleFunc("/news/comment", api.idempotent(api.addComment)).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/news/comment", api.requireRole(auth.RoleReader, api.editComment)).Methods(http.MethodPut)
+	api.r.HandleFunc("/news/comment", api.requireRole(auth.RoleReader, api.deleteComment)).Methods(http.MethodDelete)
+	api.r.HandleFunc("/news/comment/report", api.reportComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/reported", api.requireRole(auth.RoleModerator, api.reportedComments)).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/vote", api.voteComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/versions", api.commentVersions).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/replies", api.commentReplies).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/purge", api.requireRole(auth.RoleAdmin, api.purgeComment)).Methods(http.MethodDelete, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/moderation", api.requireRole(auth.RoleModerator, api.pendingComments)).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/approve", api.requireRole(auth.RoleModerator, api.approveComment)).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/news/comment/reject", api.requireRole(auth.RoleModerator, api.rejectComment)).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/users", api.addUser).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/users", api.user).Methods(http.MethodGet)
+	api.r.HandleFunc("/users", api.requireRole(auth.RoleReader, api.updateUser)).Methods(http.MethodPut)
+	api.r.HandleFunc("/users/role", api.requireRole(auth.RoleAdmin, api.setRole)).Methods(http.MethodPut, http.MethodOptions)
+	api.r.HandleFunc("/tags", api.tags).Methods(http.MethodGet, http.MethodOptions)
 }
 func (api *API) Router() *mux.Router {
 	return api.r
 }
 
 // headersMiddleware sets the Content-Type to application/json and Access-Control-Allow-Origin to *.
+// It also allows the Authorization and Idempotency-Key headers and the methods of the API
+// in cross-origin requests, so browsers can send access tokens, edit and delete comments.
 // It also catches and handles OPTIONS requests by returning a 204 No Content status.
 func headersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+idempotencyHeader)
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -100,9 +177,12 @@ func logMiddleware(next http.Handler) http.Handler {
 
 // news returns a list of news items in JSON format.
 // The page parameter is required and specifies the page number of the list of news items to return.
+// The optional tag parameter limits the list to the news items marked by that tag,
+// the optional lang parameter limits it to the news items in that language.
 // The request ID is taken from the request context.
 // If the request ID doesn't exist in the request context, a new one is generated.
 // The request ID is included in the URL query parameter "requset_id".
+// The number of comments of each news item is merged into the list, see forwardList.
 // The function returns a 200 OK status with the list of news items in JSON format.
 // If there is an error, the function returns a 400 Bad Request status with the error message.
 func (api *API) news(w http.ResponseWriter, r *http.Request) {
@@ -112,51 +192,368 @@ func (api *API) news(w http.ResponseWriter, r *http.Request) {
 		page = "1"
 	}
 	urlStr := newsUrl + "?page=" + page + "&" + reqIDStr + "=" + reqID
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		urlStr += "&tag=" + url.QueryEscape(tag)
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		urlStr += "&lang=" + url.QueryEscape(lang)
+	}
 
-	resp, err := http.Get(urlStr)
+	forwardList(w, r, urlStr)
+}
+
+// newsFilter returns a list of news items in JSON format that match the search query.
+// The page parameter is required and specifies the page number of the list of news items to return.
+// The optional keyword parameter limits the list to the news items with that extracted keyword,
+// the optional lang parameter limits it to the news items in that language.
+// The request ID is taken from the request context.
+// If the request ID doesn't exist in the request context, a new one is generated.
+// The request ID is included in the URL query parameter "requset_id".
+// The number of comments of each news item is merged into the list, see forwardList.
+// The function returns a 200 OK status with the list of news items in JSON format.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) newsFilter(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	page := r.URL.Query().Get("page")
+	if page == "" {
+		page = "1"
+	}
+	strSearch := r.URL.Query().Get("s")
+	urlStr := newsUrl + "/filter?s=" + url.QueryEscape(strSearch) + "&page=" + page + "&" + reqIDStr + "=" + reqID
+	if keyword := r.URL.Query().Get("keyword"); keyword != "" {
+		urlStr += "&keyword=" + url.QueryEscape(keyword)
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		urlStr += "&lang=" + url.QueryEscape(lang)
+	}
+
+	forwardList(w, r, urlStr)
+}
+
+// newsRevisions returns the edit history of a news item in JSON format.
+// The post ID is required and is passed as a query parameter "id".
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// Every previous version is returned along with its diff against the version that replaced it.
+// The status code and the cache validators are forwarded, see forward.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) newsRevisions(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	postID := r.URL.Query().Get("id")
+	if postID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := newsUrl + "/id/revisions?id=" + url.QueryEscape(postID) + "&" + reqIDStr + "=" + reqID
+	forward(w, r, urlStr)
+}
+
+// newsRelated returns the news items similar to a given one in JSON format.
+// The post ID is required and is passed as a query parameter "id",
+// the optional limit parameter sets the number of returned news items.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code and the cache validators are forwarded, see forward.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) newsRelated(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	postID := r.URL.Query().Get("id")
+	if postID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := newsUrl + "/id/related?id=" + url.QueryEscape(postID) + "&" + reqIDStr + "=" + reqID
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		urlStr += "&limit=" + url.QueryEscape(limit)
+	}
+
+	forward(w, r, urlStr)
+}
+
+// newsBatch returns several news items in JSON format in one round trip.
+// The ids parameter is required and holds a comma-separated list of post IDs.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The function returns the news items in the requested order along with the IDs that were not found.
+// The status code and the cache validators are forwarded, see forward.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) newsBatch(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	ids := r.URL.Query().Get("ids")
+	if ids == "" {
+		http.Error(w, "IDs not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := newsUrl + "/batch?ids=" + url.QueryEscape(ids) + "&" + reqIDStr + "=" + reqID
+
+	forward(w, r, urlStr)
+}
+
+// newsComments returns a page of the top-level comments of a news item in JSON format,
+// each with its number of replies but without the replies themselves.
+// The post ID is required and is passed as a query parameter "id".
+// The optional sort parameter sets the order: oldest (the default), newest, replies for the most replied first
+// or score for the highest voted first.
+// The optional limit parameter sets the page size, the optional cursor parameter
+// takes the NextCursor of the previous page (or the CommentsCursor of the detailed news item).
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code is forwarded, see forward.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) newsComments(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	postID := r.URL.Query().Get("id")
+	if postID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/top?id_post=" + url.QueryEscape(postID) + commentsQuery(r.URL.Query()) + "&" + reqIDStr + "=" + reqID
+
+	forward(w, r, urlStr)
+}
+
+// commentReplies returns the replies of a comment in JSON format with their own replies.
+// The comment ID is required and is passed as a query parameter "id",
+// the optional sort parameter sets the order of the replies, see newsComments.
+// Only moderators get the replies of a comment that is not approved or is deleted.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) commentReplies(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/replies?" + reqIDStr + "=" + reqID
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		urlStr += "&sort=" + url.QueryEscape(sort)
+	}
+
+	forwardComments(w, r, urlStr)
+}
+
+// deleteComment deletes a comment. The comment is kept in the thread
+// as a "[deleted]" placeholder, so its replies stay visible.
+// The comment ID is required and is passed as a query parameter "id".
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) deleteComment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// purgeComment removes a comment and all its replies for good.
+// It is allowed to admins only, see requireRole.
+// The comment ID is required and is passed as a query parameter "id".
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) purgeComment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/purge?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// pendingComments returns the moderation queue: the comments waiting for a moderator, the oldest first.
+// It is allowed to moderators only, see requireRole.
+// The optional limit parameter sets the number of returned comments.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+func (api *API) pendingComments(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	urlStr := commentsUrl + "/moderation?" + reqIDStr + "=" + reqID
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		urlStr += "&limit=" + url.QueryEscape(limit)
+	}
+
+	forwardComments(w, r, urlStr)
+}
+
+// approveComment approves a pending comment, so it is shown under its news item.
+// It is allowed to moderators only, see pendingComments.
+// The comment ID is required and is passed as a query parameter "id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) approveComment(w http.ResponseWriter, r *http.Request) {
+	api.moderateComment(w, r, "approve")
+}
+
+// rejectComment rejects a comment, so it is not shown.
+// It is allowed to moderators only, see pendingComments.
+// The comment ID is required and is passed as a query parameter "id",
+// the request body holds the reason of the rejection, e.g. {"Reason": "spam"}.
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) rejectComment(w http.ResponseWriter, r *http.Request) {
+	api.moderateComment(w, r, "reject")
+}
+
+// moderateComment forwards a moderation action on the comment with the ID
+// from the query parameter "id" to the comments service.
+func (api *API) moderateComment(w http.ResponseWriter, r *http.Request, action string) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/" + action + "?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// forwardComments sends a request with the method of the client request to the comments service
+// and writes its response to the client. The body of the client request,
+// the principal of the authenticated user, see setPrincipal,
+// and the fingerprint of the client, see fingerprint, are passed on.
+// The status code of the comments service is kept.
+// If there is an error during the request, it returns a 400 Bad Request status.
+func forwardComments(w http.ResponseWriter, r *http.Request, urlStr string) {
+	req, err := http.NewRequest(r.Method, urlStr, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setPrincipal(req, r)
+	req.Header.Set(fingerprintHeader, fingerprint(r))
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer resp.Body.Close()
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(resp.StatusCode)
 	w.Write(body)
+}
 
+// addUser registers a new user in the comments service.
+// The request body should contain the Name, the Password and optionally the About text of the user.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+func (api *API) addUser(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	urlStr := usersUrl + "?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
 }
 
-// newsFilter returns a list of news items in JSON format that match the search query.
-// The page parameter is required and specifies the page number of the list of news items to return.
+// user returns the profile of a user: the name, the About text and the number of comments.
+// The user ID is required and is passed as a query parameter "id".
 // The request ID is taken from the request context.
-// If the request ID doesn't exist in the request context, a new one is generated.
 // The request ID is included in the URL query parameter "requset_id".
-// The function returns a 200 OK status with the list of news items in JSON format.
+// The status code is forwarded, see forward.
 // If there is an error, the function returns a 400 Bad Request status with the error message.
-func (api *API) newsFilter(w http.ResponseWriter, r *http.Request) {
+func (api *API) user(w http.ResponseWriter, r *http.Request) {
 	reqID := r.Context().Value(reqIDStr).(string)
-	page := r.URL.Query().Get("page")
-	if page == "" {
-		page = "1"
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
 	}
-	strSearch := r.URL.Query().Get("s")
-	urlStr := newsUrl + "/filter?s=" + strSearch + "&page=" + page + "&" + reqIDStr + "=" + reqID
+	urlStr := usersUrl + "/" + url.PathEscape(userID) + "?" + reqIDStr + "=" + reqID
 
-	resp, err := http.Get(urlStr)
+	forward(w, r, urlStr)
+}
+
+// updateUser updates the About text of a user profile.
+// The user ID is required and is passed as a query parameter "id".
+// Only the user themselves can update the profile, the comments service checks the principal of the request.
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) updateUser(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := usersUrl + "/" + url.PathEscape(userID) + "?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// tags returns a list of tags in JSON format along with the number of news items marked by each of them.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The cache validators of the request and the response are forwarded, see forward.
+// The function returns a 200 OK status with the list of tags in JSON format.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) tags(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	urlStr := tagsUrl + "?" + reqIDStr + "=" + reqID
+
+	forward(w, r, urlStr)
+}
+
+// forward sends a GET request to a service and writes its response to the client.
+// The If-None-Match and If-Modified-Since headers of the client request are passed on,
+// and the ETag, Last-Modified and Cache-Control headers of the response are passed back,
+// so clients can revalidate their cached copies through the gateway.
+// The status code of the service is kept, a 304 Not Modified is sent without a body.
+// If there is an error during the request, it returns a 400 Bad Request status.
+func forward(w http.ResponseWriter, r *http.Request, urlStr string) {
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, h := range conditionalHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer resp.Body.Close()
+	for _, h := range cacheHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(resp.StatusCode)
 	w.Write(body)
 }
 
@@ -165,6 +562,12 @@ func (api *API) newsFilter(w http.ResponseWriter, r *http.Request) {
 // The request ID is taken from the request context.
 // If the request ID doesn't exist in the request context, a new one is generated.
 // The request ID is included in the URL query parameter "requset_id".
+// The post, the first page of its top-level comments and the related news items are requested concurrently.
+// The optional sort, limit and cursor parameters select the page of comments, see newsComments;
+// the replies of the comments are not included and are loaded with commentReplies.
+// The related news items are optional: if they can't be fetched, the post is returned without them.
+// The response carries an ETag computed over the post, its comments and the related news items,
+// a request with a matching If-None-Match header gets a 304 Not Modified status.
 // The function returns a 200 OK status with the detailed news item in JSON format.
 // If there is an error, the function returns a 400 Bad Request status with the error message.
 func (api *API) detailedNews(w http.ResponseWriter, r *http.Request) {
@@ -175,10 +578,10 @@ func (api *API) detailedNews(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := make(chan interface{}, 2)
+	result := make(chan interface{}, 3)
 	var wg sync.WaitGroup
 
-	wg.Add(2)
+	wg.Add(3)
 
 	go func() {
 		defer wg.Done()
@@ -192,7 +595,7 @@ func (api *API) detailedNews(w http.ResponseWriter, r *http.Request) {
 
 	go func() {
 		defer wg.Done()
-		comments, err := getComments(postID, reqID)
+		comments, err := getComments(postID, r.URL.Query(), reqID)
 		if err != nil {
 			result <- err
 			return
@@ -200,41 +603,178 @@ func (api *API) detailedNews(w http.ResponseWriter, r *http.Request) {
 		result <- comments
 	}()
 
+	go func() {
+		defer wg.Done()
+		related, err := getRelated(postID, reqID)
+		if err != nil {
+			log.Printf("RequestID: %s can't get related news: %s", reqID, err)
+			related = []models.NewsShortDetailed{}
+		}
+		result <- related
+	}()
+
 	go func() {
 		wg.Wait()
 		close(result)
 	}()
 
 	var post models.PostFullDetailed
-	var comments []models.Comment
+	var comments models.CommentPage
+	var related []models.NewsShortDetailed
 
 	for res := range result {
 		switch res := res.(type) {
 		case models.PostFullDetailed:
 			post = res
-		case []models.Comment:
+		case models.CommentPage:
 			comments = res
+		case []models.NewsShortDetailed:
+			related = res
 		case error:
 			http.Error(w, res.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	post.Comments = comments
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(post); err != nil {
+	post.Comments = comments.Comments
+	post.CommentsCursor = comments.NextCursor
+	post.Related = related
+	body, err := json.Marshal(post)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeWithETag(w, r, body)
+}
+
+// forwardList sends a GET request for a list of news items to the news service,
+// merges the number of comments of each news item into the list and writes it to the client.
+// The news items are passed on as they are, with all their fields; only CommentsCount is added.
+// The numbers of comments are requested from the comments service in one batch;
+// if it is not available, they are left null and the list is written anyway.
+// The Cache-Control and Last-Modified headers of the news service are passed back.
+// The numbers of comments change without the news, so the validators of the client are checked
+// against an ETag computed over the merged list, see writeWithETag, instead of being passed on.
+// The status code of the news service is kept if it is not 200 OK.
+// If there is an error during the request, it returns a 400 Bad Request status.
+func forwardList(w http.ResponseWriter, r *http.Request, urlStr string) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	resp, err := http.Get(urlStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		return
+	}
+
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(body, &list); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var posts []map[string]json.RawMessage
+	if err := json.Unmarshal(list["Posts"], &posts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(posts) > 0 {
+		ids := make([]int, len(posts))
+		strIDs := make([]string, len(posts))
+		for i, p := range posts {
+			if err := json.Unmarshal(p["ID"], &ids[i]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			strIDs[i] = strconv.Itoa(ids[i])
+			p["CommentsCount"] = json.RawMessage("null")
+		}
+		counts, err := getCommentCounts(strings.Join(strIDs, ","), reqID)
+		if err != nil {
+			log.Printf("RequestID: %s can't get comment counts: %s", reqID, err)
+		} else {
+			for i, p := range posts {
+				p["CommentsCount"] = json.RawMessage(strconv.Itoa(counts[ids[i]]))
+			}
+		}
+		if list["Posts"], err = json.Marshal(posts); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	body, err = json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, h := range []string{"Cache-Control", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	writeWithETag(w, r, body)
+}
+
+// writeWithETag writes a JSON response body along with an ETag computed over it.
+// Unless the Cache-Control header is already set, the response must be revalidated before reuse.
+// A request with a matching If-None-Match header, see notModified, gets a 304 Not Modified status.
+func writeWithETag(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// notModified reports whether the client's cached copy identified by the
+// If-None-Match request header is still fresh. The header may list several ETags,
+// weak ones included, or be "*"; the comparison is weak, as the news service does.
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
 }
 
 // addComment adds a new comment to the database.
 // The request body should contain a valid Comment struct.
-// The comment is first sent to the cenzor service for moderation.
-// If the cenzor service returns 200 OK, the comment is added to the database.
+// If the news service has no post with the PostID of the comment, it returns a 404 Not Found status,
+// if the news service fails to tell, it returns a 502 Bad Gateway status.
+// The comment is then sent to the cenzor service for moderation.
+// If the cenzor service returns 200 OK, the comment is added to the database as approved.
+// If the cenzor service returns 202 Accepted, the comment is borderline: it is added
+// as pending and shown only after a moderator approves it, the function returns a 202 Accepted status.
+// The moderation status and the AddTime sent by the client are ignored, the time is assigned by the comments service.
 // If there is an error during the cenzor request, it returns a 400 Bad Request status.
 // If the cenzor service doesn't return 200 OK, the comment is not added to the database.
+// If the comments service rejects the parent comment, it returns a 422 Unprocessable Entity status with the reason.
+// The Idempotency-Key header is passed to the comments service, which adds the comment once for the key,
+// even if the gateway doesn't hold the response anymore, see idempotent; the comment added earlier
+// is returned with the Idempotent-Replayed header, and a key reused for another comment gets a 422.
+// The author of the comment is the authenticated user, see authMiddleware; comments without a token are anonymous.
 // If there is an error during the database request, it returns a 500 Internal Server Error status.
-// Otherwise, it returns a 200 OK status.
+// Otherwise, it returns a 201 Created status, or 202 Accepted for a pending comment,
+// with the Location of the comment, see comment, and a JSON response containing the stored comment with its ID.
 func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 	reqID := r.Context().Value(reqIDStr).(string)
 	body, err := io.ReadAll(r.Body)
@@ -244,25 +784,32 @@ func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	cenzorReq, err := http.NewRequest(
-		http.MethodPost,
-		cenzorUrl+"?"+reqIDStr+"="+reqID,
-		bytes.NewBuffer(body),
-	)
-	if err != nil {
+	var comment models.Comment
+	if err := json.Unmarshal(body, &comment); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	cenzorReq.Header.Set("Content-Type", "application/json")
-	cenzorClient := &http.Client{}
-	cenzorResponse, err := cenzorClient.Do(cenzorReq)
+	exists, err := postExists(comment.PostID, reqID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !exists {
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
-	defer cenzorResponse.Body.Close()
 
-	if cenzorResponse.StatusCode == http.StatusOK {
+	comment.Status, err = censor(body, reqID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if comment.Status != "" {
+		body, err := json.Marshal(comment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		req, err := http.NewRequest(
 			http.MethodPost,
 			commentsUrl+"?"+reqIDStr+"="+reqID,
@@ -273,6 +820,11 @@ func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		req.Header.Set("Content-Type", "application/json")
+		setPrincipal(req, r)
+		if key := r.Header.Get(idempotencyHeader); key != "" {
+			req.Header.Set(idempotencyHeader, key)
+			req.Header.Set(fingerprintHeader, fingerprint(r))
+		}
 		client := &http.Client{}
 		response, err := client.Do(req)
 		if err != nil {
@@ -281,8 +833,26 @@ func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 		}
 		defer response.Body.Close()
 
-		if response.StatusCode == http.StatusOK {
-			w.WriteHeader(http.StatusOK)
+		if response.StatusCode == http.StatusCreated || response.StatusCode == http.StatusOK {
+			var stored models.Comment
+			if err := json.NewDecoder(response.Body).Decode(&stored); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/news/comment?id="+strconv.Itoa(stored.ID))
+			if response.StatusCode == http.StatusOK {
+				w.Header().Set(replayedHeader, "true")
+			}
+			if stored.Status == statusPending {
+				w.WriteHeader(http.StatusAccepted)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(stored)
+		} else if response.StatusCode == http.StatusUnprocessableEntity {
+			msg, _ := io.ReadAll(response.Body)
+			http.Error(w, strings.TrimSpace(string(msg)), response.StatusCode)
 		} else {
 			// w.WriteHeader(http.StatusInternalServerError)
 			http.Error(w, "Failed to save comment", http.StatusBadRequest)
@@ -295,6 +865,212 @@ func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// comment returns a comment by its ID. The comment ID is required and is passed as a query parameter "id".
+// Pending and rejected comments are shown only to their author and to moderators.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) comment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// editComment edits a comment. The comment ID is required and is passed as a query parameter "id",
+// the request body should contain the edited Comment struct with the same PostID and ParentID.
+// The previous content is kept in the edit history of the comment, see commentVersions.
+// The edited comment is sent to the cenzor service again, as in addComment:
+// a rejected edit is not saved and the function returns a 400 Bad Request status,
+// a borderline edit is saved as pending, the function returns a 202 Accepted status.
+// A clean edit keeps the moderation status: it doesn't approve a pending comment,
+// and the comments service refuses edits of rejected comments with a 409 Conflict status.
+// Otherwise, the status code of the comments service is forwarded.
+func (api *API) editComment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var comment models.Comment
+	if err := json.Unmarshal(body, &comment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	comment.Status, err = censor(body, reqID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if comment.Status == "" {
+		http.Error(w, "Comment was not censored", http.StatusBadRequest)
+		return
+	}
+	if comment.Status == statusApproved {
+		comment.Status = ""
+	}
+	body, err = json.Marshal(comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req, err := http.NewRequest(
+		http.MethodPut,
+		commentsUrl+"/"+url.PathEscape(commentID)+"?"+reqIDStr+"="+reqID,
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setPrincipal(req, r)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent && comment.Status == statusPending {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(msg)
+}
+
+// voteComment votes for a comment. The comment ID is required and is passed as a query parameter "id",
+// the request body holds the vote: {"Value": 1} for an upvote, {"Value": -1} for a downvote
+// or {"Value": 0} to take the vote back.
+// Each user has one vote per comment; anonymous clients are told apart by their fingerprint, see fingerprint.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) voteComment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/vote?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// reportComment reports an abusive comment to the moderators.
+// The comment ID is required and is passed as a query parameter "id", the request body holds
+// the reason of the report: {"Reason": "spam"}, "abuse", "offtopic" or "other".
+// Each user or anonymous client, see fingerprint, can report a comment once.
+// A comment with enough reports is hidden until a moderator approves it, see reportedComments.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) reportComment(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/report?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// reportedComments returns the comments reported by readers, the most reported first,
+// with the number of reports for each reason.
+// It is allowed to moderators only, see pendingComments.
+// The optional limit parameter sets the number of returned comments.
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+func (api *API) reportedComments(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	urlStr := commentsUrl + "/reported?" + reqIDStr + "=" + reqID
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		urlStr += "&limit=" + url.QueryEscape(limit)
+	}
+
+	forwardComments(w, r, urlStr)
+}
+
+// fingerprint identifies an anonymous client by the hash of its IP address.
+// The User-Agent is left out: it is set by the client, which could get a new fingerprint for every request.
+func fingerprint(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(host))
+	return hex.EncodeToString(sum[:16])
+}
+
+// commentVersions returns the edit history of a comment, the newest version first.
+// The history of pending, rejected and deleted comments is shown only to moderators.
+// The comment ID is required and is passed as a query parameter "id".
+// The request ID is taken from the request context.
+// The request ID is included in the URL query parameter "requset_id".
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) commentVersions(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	commentID := r.URL.Query().Get("id")
+	if commentID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := commentsUrl + "/" + url.PathEscape(commentID) + "/versions?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
+
+// censor sends a comment to the cenzor service and returns the moderation status for it:
+// approved if the comment passed, pending if it is borderline and waits for a moderator,
+// or an empty string if the comment was rejected.
+func censor(body []byte, reqID string) (string, error) {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		cenzorUrl+"?"+reqIDStr+"="+reqID,
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return statusApproved, nil
+	case http.StatusAccepted:
+		return statusPending, nil
+	}
+	return "", nil
+}
+
 // getPost retrieves a post by its id from the news service
 // and returns a models.PostFullDetailed struct. If there is an error
 // during the request, it returns the error. If the news service returns
@@ -326,14 +1102,64 @@ func getPost(id string, reqID string) (models.PostFullDetailed, error) {
 
 }
 
-// getComments retrieves a list of comments for a post from the comments service
-// and returns the list of comments and an error if any. If there is an error
-// during the request, it returns the error. If the comments service returns a
-// non-OK status, it returns an error with the status text as the message.
-// Otherwise, it unmarshals the JSON response into a list of models.Comment
+// postExists asks the news service whether the post with the given id exists.
+// If there is an error during the request or the news service returns
+// neither 200 OK nor 404 Not Found, it returns the error.
+func postExists(id int, reqID string) (bool, error) {
+	urlStr := newsUrl + "/id?id=" + strconv.Itoa(id) + "&" + reqIDStr + "=" + reqID
+	resp, err := http.Get(urlStr)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return false, errors.New(string(body))
+}
+
+// getComments retrieves a page of the top-level comments of a post from the comments service
+// and returns the page and an error if any. The sort mode, the page size and the cursor
+// of the page are taken from the "sort", "limit" and "cursor" parameters of the query, see commentsQuery.
+// If there is an error during the request or the comments service returns
+// a non-OK status, it returns the error.
+// Otherwise, it unmarshals the JSON response into a models.CommentPage and returns it.
+func getComments(id string, query url.Values, reqID string) (models.CommentPage, error) {
+	urlStr := commentsUrl + "/top?id_post=" + url.QueryEscape(id) + commentsQuery(query) + "&" + reqIDStr + "=" + reqID
+	var page models.CommentPage
+	if err := getJSON(urlStr, &page); err != nil {
+		return models.CommentPage{}, err
+	}
+	return page, nil
+}
+
+// commentsQuery returns the "sort", "limit" and "cursor" parameters
+// of the query that are set, to be appended to a comments service URL.
+func commentsQuery(query url.Values) string {
+	var str string
+	for _, param := range []string{"sort", "limit", "cursor"} {
+		if v := query.Get(param); v != "" {
+			str += "&" + param + "=" + url.QueryEscape(v)
+		}
+	}
+	return str
+}
+
+// getRelated retrieves the news items similar to a post from the news service.
+// If there is an error during the request or the news service returns
+// a non-OK status, it returns the error.
+// Otherwise, it unmarshals the JSON response into a list of models.NewsShortDetailed
 // and returns it.
-func getComments(id string, reqID string) ([]models.Comment, error) {
-	urlStr := commentsUrl + "?id_post=" + id + "&" + reqIDStr + "=" + reqID
+func getRelated(id string, reqID string) ([]models.NewsShortDetailed, error) {
+	urlStr := newsUrl + "/id/related?id=" + id + "&" + reqIDStr + "=" + reqID
 	resp, err := http.Get(urlStr)
 	if err != nil {
 		return nil, err
@@ -344,11 +1170,48 @@ func getComments(id string, reqID string) ([]models.Comment, error) {
 	if err != nil {
 		return nil, err
 	}
-	var comments []models.Comment
-	if err := json.Unmarshal(body, &comments); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(body))
+	}
+
+	var related []models.NewsShortDetailed
+	if err := json.Unmarshal(body, &related); err != nil {
+		return nil, err
+	}
+	return related, nil
+}
+
+// getCommentCounts retrieves the number of comments of the posts with the given
+// comma-separated IDs from the comments service.
+func getCommentCounts(ids string, reqID string) (map[int]int, error) {
+	urlStr := commentsUrl + "/count?ids=" + ids + "&" + reqIDStr + "=" + reqID
+	var counts map[int]int
+	if err := getJSON(urlStr, &counts); err != nil {
 		return nil, err
 	}
-	return comments, nil
+	return counts, nil
+}
+
+// getJSON sends a GET request and unmarshals the JSON response into v.
+// If the service returns a non-OK status, it returns an error with the response body as the message.
+func getJSON(urlStr string, v interface{}) error {
+	resp, err := http.Get(urlStr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(string(body))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("can't decode %s: %w", urlStr, err)
+	}
+	return nil
 }
 
 // idGenerator returns a random 7-digit number as a string. It is used as a request ID
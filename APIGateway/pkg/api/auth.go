@@ -0,0 +1,156 @@
+package api
+
+import (
+	"APIGateway/pkg/auth"
+	"APIGateway/pkg/models"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenTTL is how long an access token is valid.
+	tokenTTL = 24 * time.Hour
+	// principalHeader carries the authenticated user to the comments service.
+	principalHeader = "X-Principal"
+	// fingerprintHeader carries the fingerprint of an anonymous client to the comments service.
+	fingerprintHeader = "X-Client-Fingerprint"
+	// claimsKey and principalKey store the claims of the access token
+	// and the signed principal in the request context.
+	claimsKey    = "claims"
+	principalKey = "principal"
+)
+
+// token is the response of issueToken.
+type token struct {
+	Token     string `json:"Token"`
+	ExpiresAt int64  `json:"ExpiresAt"`
+	Role      string `json:"Role"`
+}
+
+// authMiddleware authenticates the request by the access token in the "Authorization: Bearer" header.
+// The claims of a valid token and the principal signed for the downstream services, see auth.Principal,
+// are stored in the request context. Requests without a token are anonymous.
+// If the token is not valid or has expired, it returns a 401 Unauthorized status.
+func (api *API) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := auth.Parse(strings.TrimSpace(bearer), api.jwtSecret, time.Now())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		principal, err := auth.Principal(claims, api.principalSecret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		ctx = context.WithValue(ctx, principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireRole allows the handler to the users with the role or a higher one, see auth.Allows.
+// The role is the one the user had when the token was issued, so it only filters the requests:
+// the comments service checks a privileged role against its current value before acting on it.
+// If the request is anonymous, it returns a 401 Unauthorized status,
+// if the role of the user is lower, it returns a 403 Forbidden status.
+func (api *API) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !auth.Allows(claims.Role, role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setPrincipal passes the signed principal of the authenticated user
+// on to a request to the comments service.
+func setPrincipal(req *http.Request, r *http.Request) {
+	if principal, ok := r.Context().Value(principalKey).(string); ok {
+		req.Header.Set(principalHeader, principal)
+	}
+}
+
+// issueToken issues an access token to a user.
+// The request body should contain the Name and the Password of the user,
+// they are checked by the comments service.
+// The token is valid for tokenTTL and holds the role of the user: reader, moderator or admin.
+// If the name or the password is wrong, the status code of the comments service is forwarded.
+// If the tokens are not configured, it returns a 503 Service Unavailable status.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+// Otherwise, it returns a 200 OK status and a JSON response containing the Token, its expiration time and the role.
+func (api *API) issueToken(w http.ResponseWriter, r *http.Request) {
+	if len(api.jwtSecret) == 0 {
+		http.Error(w, "Authentication is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	reqID := r.Context().Value(reqIDStr).(string)
+	req, err := http.NewRequest(http.MethodPost, usersUrl+"/login?"+reqIDStr+"="+reqID, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		http.Error(w, strings.TrimSpace(string(msg)), resp.StatusCode)
+		return
+	}
+	var user models.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	claims := auth.Claims{Sub: user.ID, Name: user.Name, Role: user.Role, Iat: now.Unix(), Exp: now.Add(tokenTTL).Unix()}
+	signed, err := auth.Sign(claims, api.jwtSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(token{Token: signed, ExpiresAt: claims.Exp, Role: claims.Role})
+}
+
+// setRole changes the role of a user to reader, moderator or admin.
+// It is allowed to admins only, see requireRole.
+// The user ID is required and is passed as a query parameter "id",
+// the request body holds the role, e.g. {"Role": "moderator"}.
+// The new role takes effect when the user gets a new token.
+// The status code of the comments service is forwarded, see forwardComments.
+// If there is an error, the function returns a 400 Bad Request status with the error message.
+func (api *API) setRole(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(reqIDStr).(string)
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		http.Error(w, "ID not found.", http.StatusBadRequest)
+		return
+	}
+	urlStr := usersUrl + "/" + url.PathEscape(userID) + "/role?" + reqIDStr + "=" + reqID
+
+	forwardComments(w, r, urlStr)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"APIGateway/pkg/auth"
+	"APIGateway/pkg/idempotency"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// idempotencyHeader carries the idempotency key of a request chosen by the client.
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader marks a replayed response.
+	replayedHeader = "Idempotent-Replayed"
+	// idempotencyTTL is how long the response to a request with an idempotency key is kept by default.
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKey is the longest idempotency key.
+	maxIdempotencyKey = 255
+	// maxIdempotencyKeys is how many idempotency keys are held by default.
+	maxIdempotencyKeys = 10000
+)
+
+// idempotent makes the handler safe to retry: the response to a request with the Idempotency-Key header
+// is kept for the idempotency_ttl, while the store isn't full, see New, and replayed with the Idempotent-Replayed header
+// to the requests with the same key and body, instead of handling them again.
+// A request with the key of a request still in progress waits for its response.
+// Only successful responses are kept, so a failed request can be retried with the same key.
+// The keys of each user, or anonymous client, see fingerprint, are separate.
+// Requests without the header are handled as usual.
+// If the key is longer than maxIdempotencyKey, it returns a 400 Bad Request status.
+// If the key is reused with another body, it returns a 422 Unprocessable Entity status.
+// If the request is cancelled while waiting, it returns a 409 Conflict status.
+func (api *API) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+
+		scope := "client:" + fingerprint(r)
+		if claims, ok := r.Context().Value(claimsKey).(auth.Claims); ok {
+			scope = "user:" + strconv.Itoa(claims.Sub)
+		}
+		resp, replayed, err := api.idempotency.Do(r.Context(), scope+"|"+key, hex.EncodeToString(sum[:]), func() idempotency.Response {
+			rec := idempotency.NewRecorder()
+			next(rec, r)
+			return rec.Response()
+		})
+		if errors.Is(err, idempotency.ErrMismatch) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, "A request with the same idempotency key is in progress", http.StatusConflict)
+			return
+		}
+		if replayed {
+			w.Header().Set(replayedHeader, "true")
+		}
+		resp.Write(w)
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"APIGateway/pkg/models"
+	"APIGateway/pkg/trending"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// trendingPages is the number of the latest pages of news ranked along with the discussed ones.
+	trendingPages int = 3
+	// trendingSize is the number of news items in the trending list.
+	trendingSize int = 20
+	// trendingBatch is the largest number of discussed news items fetched in one batch.
+	trendingBatch int = 100
+	// trendingRecent is the time within which comments count as recent.
+	trendingRecent time.Duration = 6 * time.Hour
+	// trendingRetry is how soon the trending news are recomputed after a failure,
+	// until they are computed for the first time.
+	trendingRetry time.Duration = 5 * time.Second
+)
+
+// trendingCache holds the last computed list of trending news.
+type trendingCache struct {
+	m       sync.RWMutex
+	news    []models.TrendingNews
+	updated time.Time
+}
+
+// RefreshTrending recomputes the trending news every period.
+// Until the first computation succeeds, a failed one is retried after trendingRetry,
+// since the trending news are not served before it, see trendingNews.
+// It is meant to be run in its own goroutine.
+func (api *API) RefreshTrending(period time.Duration) {
+	for {
+		delay := period
+		if err := api.updateTrending(); err != nil {
+			log.Printf("can't update trending news: %s", err)
+			api.trending.m.RLock()
+			if api.trending.updated.IsZero() {
+				delay = trendingRetry
+			}
+			api.trending.m.RUnlock()
+		}
+		time.Sleep(delay)
+	}
+}
+
+// updateTrending ranks the latest news and the recently discussed ones
+// and stores the result in the cache.
+// If the comments service is not available, the news are ranked by their recency only.
+func (api *API) updateTrending() error {
+	reqID := idGenerator()
+	now := time.Now()
+
+	activity, err := getActivity(now.Add(-trendingRecent).Unix(), reqID)
+	if err != nil {
+		log.Printf("RequestID: %s can't get comment activity: %s", reqID, err)
+	}
+
+	var news []models.NewsShortDetailed
+	for page := 1; page <= trendingPages; page++ {
+		latest, err := getNewsPage(page, reqID)
+		if err != nil {
+			return err
+		}
+		news = append(news, latest...)
+	}
+	if len(activity) > 0 {
+		ids := make([]string, 0, trendingBatch)
+		for i := 0; i < len(activity) && i < trendingBatch; i++ {
+			ids = append(ids, strconv.Itoa(activity[i].PostID))
+		}
+		discussed, err := getNewsBatch(strings.Join(ids, ","), reqID)
+		if err != nil {
+			return err
+		}
+		news = append(news, discussed...)
+	}
+
+	counts, err := getQuietCounts(news, activity, reqID)
+	if err != nil {
+		log.Printf("RequestID: %s can't get comment counts: %s", reqID, err)
+	}
+
+	ranked := trending.Rank(news, activity, counts, now.Unix(), trendingSize)
+	api.trending.m.Lock()
+	api.trending.news = ranked
+	api.trending.updated = now
+	api.trending.m.Unlock()
+	return nil
+}
+
+// getQuietCounts retrieves the number of comments of the news items that have no recent
+// comment activity, in batches of at most trendingBatch IDs.
+// The counts retrieved before an error are returned along with it.
+func getQuietCounts(news []models.NewsShortDetailed, activity []models.CommentActivity, reqID string) (map[int]int, error) {
+	active := make(map[int]bool, len(activity))
+	for _, a := range activity {
+		active[a.PostID] = true
+	}
+	var ids []string
+	for _, n := range news {
+		if !active[n.ID] {
+			active[n.ID] = true
+			ids = append(ids, strconv.Itoa(n.ID))
+		}
+	}
+
+	counts := make(map[int]int, len(ids))
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), trendingBatch)]
+		ids = ids[len(batch):]
+		batchCounts, err := getCommentCounts(strings.Join(batch, ","), reqID)
+		if err != nil {
+			return counts, err
+		}
+		for id, count := range batchCounts {
+			counts[id] = count
+		}
+	}
+	return counts, nil
+}
+
+// trendingNews returns the list of trending news in JSON format: the latest news
+// and the recently discussed ones ranked by a score that combines the number
+// of comments, the number of recent comments and the age of a news item, see trending.Score.
+// The list is recomputed periodically by RefreshTrending and is never computed for a request,
+// so that a burst of requests doesn't flood the news and comments services.
+// If the list hasn't been computed yet, it returns a 503 Service Unavailable status with a Retry-After header.
+// The response carries a Last-Modified header with the time the list was computed.
+func (api *API) trendingNews(w http.ResponseWriter, r *http.Request) {
+	api.trending.m.RLock()
+	news, updated := api.trending.news, api.trending.updated
+	api.trending.m.RUnlock()
+	if updated.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(trendingRetry.Seconds())))
+		http.Error(w, "Trending news are not computed yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := json.Marshal(news)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// getActivity retrieves the comment activity of the posts commented since the given Unix time
+// from the comments service. If there is an error during the request or the comments service
+// returns a non-OK status, it returns the error.
+func getActivity(since int64, reqID string) ([]models.CommentActivity, error) {
+	urlStr := commentsUrl + "/activity?since=" + strconv.FormatInt(since, 10) + "&" + reqIDStr + "=" + reqID
+	var activity []models.CommentActivity
+	if err := getJSON(urlStr, &activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// getNewsPage retrieves a page of the latest news from the news service.
+func getNewsPage(page int, reqID string) ([]models.NewsShortDetailed, error) {
+	urlStr := newsUrl + "?page=" + strconv.Itoa(page) + "&" + reqIDStr + "=" + reqID
+	var res models.NewsList
+	if err := getJSON(urlStr, &res); err != nil {
+		return nil, err
+	}
+	return res.Posts, nil
+}
+
+// getNewsBatch retrieves the news items with the given comma-separated IDs from the news service.
+// The IDs that are not found are skipped.
+func getNewsBatch(ids string, reqID string) ([]models.NewsShortDetailed, error) {
+	urlStr := newsUrl + "/batch?ids=" + ids + "&" + reqIDStr + "=" + reqID
+	var res struct {
+		Posts []models.NewsShortDetailed
+	}
+	if err := getJSON(urlStr, &res); err != nil {
+		return nil, err
+	}
+	return res.Posts, nil
+}
@@ -0,0 +1,131 @@
+// Package auth issues and verifies the access tokens of users:
+// JSON Web Tokens signed with HMAC-SHA256.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Roles of users. Readers write comments, moderators also moderate
+// the comments of others, admins also manage the roles of users.
+const (
+	RoleReader    = "reader"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// rank orders the roles: a role is allowed everything the lower ones are.
+var rank = map[string]int{RoleReader: 1, RoleModerator: 2, RoleAdmin: 3}
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature doesn't match.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired is returned when a token has expired.
+	ErrExpired = errors.New("token has expired")
+)
+
+// header is the JOSE header of the issued tokens.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the claims of an access token: the ID, the name and the role of the user,
+// the issue and the expiration time.
+type Claims struct {
+	Sub  int    `json:"sub"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Iat  int64  `json:"iat"`
+	Exp  int64  `json:"exp"`
+}
+
+// Allows reports whether the role is allowed what the required role is.
+func Allows(role, required string) bool {
+	return rank[role] > 0 && rank[role] >= rank[required]
+}
+
+// Sign returns the token with the claims signed with the secret.
+func Sign(c Claims, secret []byte) (string, error) {
+	payload, err := encode(c)
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + payload
+	return unsigned + "." + sign(unsigned, secret), nil
+}
+
+// Parse verifies the token with the secret and returns its claims.
+// Only HS256 tokens are accepted, a token whose expiration time is not after now is refused.
+func Parse(token string, secret []byte, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(secret) == 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := decode(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return Claims{}, ErrInvalidToken
+	}
+	got, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(sign(parts[0]+"."+parts[1], secret))
+	if !hmac.Equal(got, want) {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := decode(parts[1], &c); err != nil || c.Sub <= 0 || rank[c.Role] == 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	if now.Unix() >= c.Exp {
+		return Claims{}, ErrExpired
+	}
+	return c, nil
+}
+
+// Principal returns the value of the principal header passed to the downstream services:
+// the ID, the name, the role and the expiration time of the user, signed with the secret
+// shared with the services.
+func Principal(c Claims, secret []byte) (string, error) {
+	payload, err := encode(struct {
+		ID   int    `json:"ID"`
+		Name string `json:"Name"`
+		Role string `json:"Role"`
+		Exp  int64  `json:"Exp"`
+	}{c.Sub, c.Name, c.Role, c.Exp})
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + sign(payload, secret), nil
+}
+
+// sign returns the base64url-encoded HMAC-SHA256 of the data.
+func sign(data string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encode returns the base64url-encoded JSON of v.
+func encode(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decode decodes the base64url-encoded JSON into v.
+func decode(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignParse(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	want := Claims{Sub: 7, Name: "reader", Role: RoleModerator, Iat: now.Unix(), Exp: now.Unix() + 60}
+
+	token, err := Sign(want, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(token, secret, now)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+
+	if _, err := Parse(token, []byte("guess"), now); err != ErrInvalidToken {
+		t.Errorf("Parse() with another secret error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := Parse(token, secret, now.Add(time.Minute)); err != ErrExpired {
+		t.Errorf("Parse() of an expired token error = %v, want %v", err, ErrExpired)
+	}
+
+	// A token with the role changed by the client must be refused.
+	parts := strings.Split(token, ".")
+	forged, _ := encode(Claims{Sub: 7, Name: "reader", Role: RoleAdmin, Exp: want.Exp})
+	if _, err := Parse(parts[0]+"."+forged+"."+parts[2], secret, now); err != ErrInvalidToken {
+		t.Errorf("Parse() of a forged token error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	// Unsigned tokens must be refused.
+	none, _ := encode(map[string]string{"alg": "none"})
+	if _, err := Parse(none+"."+parts[1]+".", secret, now); err != ErrInvalidToken {
+		t.Errorf("Parse() of an unsigned token error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{RoleReader, RoleReader, true},
+		{RoleReader, RoleModerator, false},
+		{RoleModerator, RoleReader, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleModerator, true},
+		{"", RoleReader, false},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.role, tt.required); got != tt.want {
+			t.Errorf("Allows(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
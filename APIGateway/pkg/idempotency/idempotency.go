@@ -0,0 +1,190 @@
+// Package idempotency makes retried requests safe: the response to a request
+// is stored under the idempotency key sent by the client and replayed to the
+// retries of the request with the same key, instead of executing it again.
+//
+// A retry that arrives while the original request is still executing waits
+// for its response. Only successful responses are stored: a failed request
+// has changed nothing, so its retry is executed again.
+//
+// The store holds a limited number of keys: when it is full, the oldest response
+// is dropped, so the store is a cache and the service behind it must still
+// recognize the retries it no longer holds.
+package idempotency
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrMismatch is returned when an idempotency key is reused for a request with another body.
+var ErrMismatch = errors.New("idempotency key is already used for another request")
+
+// Response is a stored response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// entry is the state of a key: in flight until done is closed,
+// then holding the response until it expires.
+type entry struct {
+	hash    string
+	done    chan struct{}
+	resp    *Response
+	expires time.Time
+	// stored is the element of the key in Store.stored once the response is stored.
+	stored *list.Element
+}
+
+// Store holds the responses of requests by their idempotency keys.
+type Store struct {
+	m       sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*entry
+	// stored holds the keys of the stored responses, the oldest first,
+	// which is also the order in which they expire.
+	stored *list.List
+	now    func() time.Time
+}
+
+// New creates a store that keeps a response for ttl after it is stored
+// and holds at most max keys.
+func New(ttl time.Duration, max int) *Store {
+	return &Store{ttl: ttl, max: max, entries: make(map[string]*entry), stored: list.New(), now: time.Now}
+}
+
+// Do executes fn once for the key and the hash of the request and returns its response.
+// The response is replayed to the later calls with the same key, which report replayed.
+// The calls made while fn is executing wait for it or until ctx is done, then return ctx.Err().
+// If fn returns an unsuccessful response, it is not stored and the next call executes fn again.
+// If the key is used with another hash, Do returns ErrMismatch.
+// If the store is full, the oldest response is dropped; if all the keys are in flight,
+// fn is executed without storing its response.
+func (s *Store) Do(ctx context.Context, key, hash string, fn func() Response) (resp Response, replayed bool, err error) {
+	for {
+		s.m.Lock()
+		s.expire()
+		e, ok := s.entries[key]
+		if ok && e.hash != hash {
+			s.m.Unlock()
+			return Response{}, false, ErrMismatch
+		}
+		if !ok {
+			if len(s.entries) >= s.max {
+				if s.stored.Len() == 0 {
+					s.m.Unlock()
+					return fn(), false, nil
+				}
+				s.remove(s.stored.Front())
+			}
+			e = &entry{hash: hash, done: make(chan struct{})}
+			s.entries[key] = e
+			s.m.Unlock()
+			return s.execute(key, e, fn), false, nil
+		}
+		s.m.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return Response{}, false, ctx.Err()
+		}
+		if e.resp != nil {
+			return *e.resp, true, nil
+		}
+	}
+}
+
+// execute runs fn for the new entry of the key and stores its response if it is successful.
+// The entry is removed if fn fails or panics, so that the waiting calls try again.
+func (s *Store) execute(key string, e *entry, fn func() Response) (resp Response) {
+	defer func() {
+		s.m.Lock()
+		if e.resp == nil {
+			delete(s.entries, key)
+		}
+		close(e.done)
+		s.m.Unlock()
+	}()
+
+	resp = fn()
+	if resp.Status >= 200 && resp.Status < 300 {
+		s.m.Lock()
+		e.resp = &resp
+		e.expires = s.now().Add(s.ttl)
+		e.stored = s.stored.PushBack(key)
+		s.m.Unlock()
+	}
+	return resp
+}
+
+// expire removes the expired responses. The caller must hold the lock.
+func (s *Store) expire() {
+	now := s.now()
+	for el := s.stored.Front(); el != nil; el = s.stored.Front() {
+		if now.Before(s.entries[el.Value.(string)].expires) {
+			return
+		}
+		s.remove(el)
+	}
+}
+
+// remove removes the stored response of the key held by the element. The caller must hold the lock.
+func (s *Store) remove(el *list.Element) {
+	delete(s.entries, s.stored.Remove(el).(string))
+}
+
+// Recorder is an http.ResponseWriter that records the response of a handler.
+type Recorder struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+// NewRecorder creates a Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{header: make(http.Header)}
+}
+
+// Header returns the header of the response.
+func (rec *Recorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader records the status code of the response.
+func (rec *Recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// Write records the body of the response.
+func (rec *Recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// Response returns the recorded response.
+func (rec *Recorder) Response() Response {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return Response{Status: status, Header: rec.header.Clone(), Body: bytes.Clone(rec.body.Bytes())}
+}
+
+// Write writes the response to w.
+func (resp Response) Write(w http.ResponseWriter) {
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
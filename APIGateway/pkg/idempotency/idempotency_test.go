@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	s := New(time.Hour, 10)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	calls := 0
+	created := func() Response {
+		calls++
+		return Response{Status: http.StatusCreated, Body: []byte(`{"ID":1}`)}
+	}
+	resp, replayed, err := s.Do(ctx, "key", "body", created)
+	if err != nil || replayed || resp.Status != http.StatusCreated {
+		t.Fatalf("Do() = %d, %v, %v, want %d, false, nil", resp.Status, replayed, err, http.StatusCreated)
+	}
+	resp, replayed, err = s.Do(ctx, "key", "body", created)
+	if err != nil || !replayed || string(resp.Body) != `{"ID":1}` {
+		t.Errorf("Do() of a retry = %q, %v, %v, want the stored response", resp.Body, replayed, err)
+	}
+	if calls != 1 {
+		t.Errorf("the request was executed %d times, want 1", calls)
+	}
+
+	if _, _, err := s.Do(ctx, "key", "another body", created); err != ErrMismatch {
+		t.Errorf("Do() with another body error = %v, want %v", err, ErrMismatch)
+	}
+
+	// Failed requests are not stored.
+	failed := func() Response { return Response{Status: http.StatusBadRequest} }
+	s.Do(ctx, "failed", "body", failed)
+	if _, replayed, _ := s.Do(ctx, "failed", "body", created); replayed {
+		t.Error("Do() replayed a failed request")
+	}
+
+	// Responses expire after the ttl.
+	now = now.Add(time.Hour)
+	if _, replayed, _ := s.Do(ctx, "key", "body", created); replayed {
+		t.Error("Do() replayed an expired response")
+	}
+}
+
+func TestDoInFlight(t *testing.T) {
+	s := New(time.Hour, 10)
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := func() Response {
+		close(started)
+		<-release
+		return Response{Status: http.StatusCreated}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Do(context.Background(), "key", "body", slow)
+	}()
+	<-started
+
+	// A retry waits for the request in flight and gets its response.
+	done := make(chan bool)
+	go func() {
+		_, replayed, _ := s.Do(context.Background(), "key", "body", func() Response {
+			return Response{Status: http.StatusCreated}
+		})
+		done <- replayed
+	}()
+
+	// A retry that gives up waiting gets the error of its context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := s.Do(ctx, "key", "body", slow); err != context.Canceled {
+		t.Errorf("Do() of a cancelled retry error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	wg.Wait()
+	if !<-done {
+		t.Error("Do() executed the request in flight again")
+	}
+}
+
+func TestDoFull(t *testing.T) {
+	s := New(time.Hour, 2)
+	ctx := context.Background()
+	created := func() Response { return Response{Status: http.StatusCreated} }
+
+	s.Do(ctx, "first", "body", created)
+	s.Do(ctx, "second", "body", created)
+	s.Do(ctx, "third", "body", created)
+	if len(s.entries) != 2 {
+		t.Errorf("the store holds %d keys, want 2", len(s.entries))
+	}
+	if _, replayed, _ := s.Do(ctx, "first", "body", created); replayed {
+		t.Error("Do() replayed the oldest response of a full store")
+	}
+	if _, replayed, _ := s.Do(ctx, "third", "body", created); !replayed {
+		t.Error("Do() didn't replay the newest response")
+	}
+
+	// When all the keys are in flight, the request is executed without storing its response.
+	s = New(time.Hour, 1)
+	started, release := make(chan struct{}), make(chan struct{})
+	go s.Do(ctx, "slow", "body", func() Response {
+		close(started)
+		<-release
+		return Response{Status: http.StatusCreated}
+	})
+	<-started
+	s.Do(ctx, "other", "body", created)
+	if _, ok := s.entries["other"]; ok {
+		t.Error("Do() stored a response in a store full of requests in flight")
+	}
+	close(release)
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	rec.Header().Set("Location", "/news/comment?id=1")
+	rec.WriteHeader(http.StatusAccepted)
+	rec.Write([]byte("ok"))
+
+	resp := rec.Response()
+	if resp.Status != http.StatusAccepted || string(resp.Body) != "ok" || resp.Header.Get("Location") != "/news/comment?id=1" {
+		t.Errorf("Response() = %+v", resp)
+	}
+}
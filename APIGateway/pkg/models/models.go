@@ -4,24 +4,105 @@ type PostFullDetailed struct {
 	ID       int       `json:"ID"`
 	Title    string    `json:"Title"`
 	Content  string    `json:"Content"`
+	Summary  string    `json:"Summary"`
 	PubTime  int64     `json:"PubTime"`
 	Link     string    `json:"Link"`
+	Lang     string    `json:"Lang"`
+	Tags     []string  `json:"Tags"`
+	Keywords []string  `json:"Keywords"`
 	Comments []Comment `json:"Comments"`
+	// CommentsCursor points at the next page of comments, it is empty on the last page.
+	CommentsCursor string              `json:"CommentsCursor"`
+	Related        []NewsShortDetailed `json:"Related"`
 }
 
 type NewsShortDetailed struct {
-	ID      int    `json:"ID"`
-	Title   string `json:"Title"`
-	PubTime int64  `json:"PubTime"`
-	Link    string `json:"Link"`
+	ID       int      `json:"ID"`
+	Title    string   `json:"Title"`
+	Summary  string   `json:"Summary"`
+	PubTime  int64    `json:"PubTime"`
+	Link     string   `json:"Link"`
+	Lang     string   `json:"Lang"`
+	Tags     []string `json:"Tags"`
+	Keywords []string `json:"Keywords"`
+	// CommentsCount is null if the comments service is not available.
+	CommentsCount *int `json:"CommentsCount"`
+}
+
+type Pagination struct {
+	CurrentPage   int `json:"CurrentPage"`
+	TotalPages    int `json:"TotalPages"`
+	NumberOfPosts int `json:"NumberOfPosts"`
+}
+
+type NewsList struct {
+	Posts      []NewsShortDetailed `json:"Posts"`
+	Pagination Pagination          `json:"Pagination"`
+}
+
+type NewsBatch struct {
+	Posts   []PostFullDetailed `json:"Posts"`
+	Missing []int              `json:"Missing"`
 }
 
 type Comment struct {
-	ID       int       `json:"ID"`
-	PostID   int       `json:"PostID"`
-	ParentID int       `json:"ParentID"`
-	Content  string    `json:"Content"`
-	AddTime  int64     `json:"AddTime"`
-	Visible  bool      `json:"Visible"`
-	Replies  []Comment `json:"Replies"`
+	ID           int       `json:"ID"`
+	PostID       int       `json:"PostID"`
+	ParentID     int       `json:"ParentID"`
+	Content      string    `json:"Content"`
+	AddTime      int64     `json:"AddTime"`
+	Status       string    `json:"Status"`
+	RejectReason string    `json:"RejectReason"`
+	Deleted      bool      `json:"Deleted"`
+	Replies      []Comment `json:"Replies"`
+	// ReplyCount is the number of direct replies,
+	// set when a comment is listed without its replies.
+	ReplyCount int `json:"ReplyCount"`
+	// EditedAt is the time of the last edit, 0 if the comment was not edited.
+	EditedAt  int64 `json:"EditedAt"`
+	EditCount int   `json:"EditCount"`
+	// AuthorID is the ID of the user who wrote the comment, 0 for anonymous comments.
+	AuthorID   int    `json:"AuthorID"`
+	AuthorName string `json:"AuthorName"`
+	// Score is the number of upvotes minus the number of downvotes.
+	Score int `json:"Score"`
+}
+
+type User struct {
+	ID           int    `json:"ID"`
+	Name         string `json:"Name"`
+	About        string `json:"About"`
+	CreatedAt    int64  `json:"CreatedAt"`
+	Role         string `json:"Role"`
+	CommentCount int    `json:"CommentCount"`
+}
+
+type ReportedComment struct {
+	Comment
+	ReportCount int            `json:"ReportCount"`
+	Reasons     map[string]int `json:"Reasons"`
+}
+
+type CommentVersion struct {
+	ID        int    `json:"ID"`
+	CommentID int    `json:"CommentID"`
+	Content   string `json:"Content"`
+	EditedAt  int64  `json:"EditedAt"`
+}
+
+type CommentPage struct {
+	Comments   []Comment `json:"Comments"`
+	NextCursor string    `json:"NextCursor"`
+}
+
+type CommentActivity struct {
+	PostID int `json:"PostID"`
+	Count  int `json:"Count"`
+	Recent int `json:"Recent"`
+}
+
+type TrendingNews struct {
+	NewsShortDetailed
+	RecentComments int     `json:"RecentComments"`
+	Score          float64 `json:"Score"`
 }
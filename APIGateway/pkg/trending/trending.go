@@ -0,0 +1,74 @@
+// Package trending ranks news by their recency and comment activity.
+//
+// The score of a news item grows with the number of its comments and, faster,
+// with the number of its recent comments, and decays with the age of the item:
+//
+//	score = (1 + comments + RecentWeight * recent) / (age in hours + 2) ^ Gravity
+//
+// so a fresh item with a lively discussion outranks both an old item with
+// many comments and a fresh item nobody talks about.
+package trending
+
+import (
+	"APIGateway/pkg/models"
+	"math"
+	"sort"
+)
+
+const (
+	// RecentWeight is how much more a recent comment counts than an old one.
+	RecentWeight float64 = 3
+	// Gravity is how fast the score decays with the age of a news item.
+	Gravity float64 = 1.5
+)
+
+// Score returns the score of a news item published at pubTime that has count comments,
+// recent of which were added recently, at the Unix time now.
+func Score(pubTime int64, count, recent int, now int64) float64 {
+	age := math.Max(float64(now-pubTime), 0) / 3600
+	points := 1 + float64(count) + RecentWeight*float64(recent)
+	return points / math.Pow(age+2, Gravity)
+}
+
+// Rank scores the news items by their comment activity at the Unix time now
+// and returns at most limit of them, the highest score first.
+// The news items without recent activity take their number of comments from counts;
+// if they are missing there too, their CommentsCount is left nil, since it is not known.
+func Rank(news []models.NewsShortDetailed, activity []models.CommentActivity, counts map[int]int, now int64, limit int) []models.TrendingNews {
+	byPost := make(map[int]models.CommentActivity, len(activity))
+	for _, a := range activity {
+		byPost[a.PostID] = a
+	}
+
+	ranked := make([]models.TrendingNews, 0, len(news))
+	seen := make(map[int]bool, len(news))
+	for _, n := range news {
+		if seen[n.ID] {
+			continue
+		}
+		seen[n.ID] = true
+		a, ok := byPost[n.ID]
+		if !ok {
+			a.Count, ok = counts[n.ID]
+		}
+		if ok {
+			count := a.Count
+			n.CommentsCount = &count
+		}
+		ranked = append(ranked, models.TrendingNews{
+			NewsShortDetailed: n,
+			RecentComments:    a.Recent,
+			Score:             Score(n.PubTime, a.Count, a.Recent, now),
+		})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].PubTime > ranked[j].PubTime
+	})
+	if limit < len(ranked) {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
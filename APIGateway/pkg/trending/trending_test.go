@@ -0,0 +1,55 @@
+package trending
+
+import (
+	"APIGateway/pkg/models"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	const hour = 3600
+	now := int64(100 * hour)
+	news := []models.NewsShortDetailed{
+		{ID: 1, Title: "fresh and quiet", PubTime: now - hour},
+		{ID: 2, Title: "old and discussed", PubTime: now - 48*hour},
+		{ID: 3, Title: "fresh and discussed", PubTime: now - 2*hour},
+		{ID: 4, Title: "old and quiet", PubTime: now - 72*hour},
+	}
+	activity := []models.CommentActivity{
+		{PostID: 2, Count: 40, Recent: 0},
+		{PostID: 3, Count: 10, Recent: 8},
+	}
+
+	got := Rank(news, activity, nil, now, 3)
+	want := []int{3, 1, 2}
+	if len(got) != len(want) {
+		t.Fatalf("Rank() returned %d news, want %d", len(got), len(want))
+	}
+	for i, id := range want {
+		if got[i].ID != id {
+			t.Errorf("Rank()[%d] = %q, want news %d", i, got[i].Title, id)
+		}
+	}
+	if *got[0].CommentsCount != 10 || got[0].RecentComments != 8 {
+		t.Errorf("Rank()[0] has %d comments, %d recent, want 10 and 8", *got[0].CommentsCount, got[0].RecentComments)
+	}
+}
+
+func TestRankQuietNews(t *testing.T) {
+	const hour = 3600
+	now := int64(100 * hour)
+	news := []models.NewsShortDetailed{
+		{ID: 1, Title: "discussed long ago", PubTime: now - hour},
+		{ID: 2, Title: "count unknown", PubTime: now - 2*hour},
+	}
+
+	got := Rank(news, nil, map[int]int{1: 7}, now, 2)
+	if len(got) != 2 || got[0].ID != 1 {
+		t.Fatalf("Rank() = %v, want news 1 first", got)
+	}
+	if got[0].CommentsCount == nil || *got[0].CommentsCount != 7 || got[0].RecentComments != 0 {
+		t.Errorf("Rank()[0] has %v comments, %d recent, want 7 and 0", got[0].CommentsCount, got[0].RecentComments)
+	}
+	if got[1].CommentsCount != nil {
+		t.Errorf("Rank()[1] has %d comments, want null", *got[1].CommentsCount)
+	}
+}
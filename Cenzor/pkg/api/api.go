@@ -55,7 +55,26 @@ func logMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// cenzor censors a comment and returns a 200 OK status if the comment is clean,
+// Statuses of a verdict.
+const (
+	StatusApproved = "approved"
+	StatusReview   = "review"
+	StatusRejected = "rejected"
+)
+
+// maxLinks is the number of links a comment can have without being reviewed.
+const maxLinks = 2
+
+var (
+	// swearWords make a comment rejected.
+	swearWords = []string{"йцукен", "ячсмит", "пролсд"}
+	// borderlineWords make a comment reviewed by a moderator.
+	borderlineWords = []string{"дурак", "идиот", "тупой", "stupid", "idiot", "moron"}
+)
+
+// cenzor censors a comment and returns its verdict in JSON format:
+// a 200 OK status if the comment is clean, a 202 Accepted status if the comment
+// is borderline and needs a review by a moderator,
 // and a 400 Bad Request status if the comment is censored.
 // The comment is passed in the request body as a JSON object.
 // The request ID is passed as a query parameter "requset_id".
@@ -69,13 +88,36 @@ func (api *API) cenzor(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	status := censored(comment.Content)
+	verdict := check(comment.Content)
 
-	if status {
+	switch verdict.Status {
+	case StatusApproved:
 		w.WriteHeader(http.StatusOK)
-	} else {
+	case StatusReview:
+		w.WriteHeader(http.StatusAccepted)
+	default:
 		w.WriteHeader(http.StatusBadRequest)
 	}
+	json.NewEncoder(w).Encode(verdict)
+}
+
+// check returns the verdict on a comment.
+// A comment with swear words is rejected, a comment with borderline words
+// or with more than maxLinks links needs a review, any other comment is approved.
+func check(comment string) models.Verdict {
+	if !censored(comment) {
+		return models.Verdict{Status: StatusRejected, Reason: "swear words"}
+	}
+	lower := strings.ToLower(comment)
+	for _, word := range borderlineWords {
+		if strings.Contains(lower, word) {
+			return models.Verdict{Status: StatusReview, Reason: "borderline words"}
+		}
+	}
+	if strings.Count(lower, "http://")+strings.Count(lower, "https://") > maxLinks {
+		return models.Verdict{Status: StatusReview, Reason: "too many links"}
+	}
+	return models.Verdict{Status: StatusApproved}
 }
 
 // censored checks if a comment contains swear words.
@@ -83,8 +125,6 @@ func (api *API) cenzor(w http.ResponseWriter, r *http.Request) {
 // If the comment contains any of the swear words, it returns false.
 // Otherwise, it returns true.
 func censored(comment string) bool {
-	swearWords := []string{"йцукен", "ячсмит", "пролсд"}
-
 	for _, swearWord := range swearWords {
 		if strings.Contains(strings.ToLower(comment), swearWord) {
 			return false
@@ -38,6 +38,20 @@ func TestCenzor(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 		},
+		{
+			name: "Borderline comment",
+			comment: models.Comment{
+				Content: "Only an idiot would write loops like this.",
+			},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name: "Comment with many links",
+			comment: models.Comment{
+				Content: "See https://a.example, https://b.example and https://c.example.",
+			},
+			expectedCode: http.StatusAccepted,
+		},
 		{
 			name: "Empty comment",
 			comment: models.Comment{
@@ -8,3 +8,11 @@ type Comment struct {
 	AddTime  int64     `json:"AddTime"`
 	Replies  []Comment `json:"Replies"`
 }
+
+// Verdict is the decision of the cenzor on a comment.
+// Status is "approved", "review" for borderline comments a moderator has to look at,
+// or "rejected". Reason explains why a comment was not approved.
+type Verdict struct {
+	Status string `json:"Status"`
+	Reason string `json:"Reason"`
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"Comments/pkg/api"
 	"Comments/pkg/db"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -16,12 +17,23 @@ type server struct {
 }
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply database migrations and exit")
+	flag.Parse()
+
 	var srv server
 	var err error
 	srv.db, err = db.New()
 	if err != nil {
 		log.Fatal(err)
 	}
+	n, err := srv.db.Migrate()
+	if err != nil {
+		log.Fatal("failed to migrate database: ", err)
+	}
+	log.Printf("applied %d database migrations", n)
+	if *migrateOnly {
+		return
+	}
 	srv.api = api.New(srv.db)
 	port := ":8082"
 	logFilePath := "comments.log"
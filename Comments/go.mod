@@ -24,6 +24,6 @@ require (
 	github.com/jackc/puddle v1.3.0 // indirect
 	github.com/stretchr/testify v1.9.0
 	github.com/subosito/gotenv v1.6.0
-	golang.org/x/crypto v0.20.0 // indirect
+	golang.org/x/crypto v0.20.0
 	golang.org/x/text v0.14.0 // indirect
 )
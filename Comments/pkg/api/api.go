@@ -3,11 +3,15 @@ package api
 import (
 	"Comments/pkg/db"
 	"Comments/pkg/models"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,18 +19,50 @@ import (
 
 const (
 	reqIDStr string = "requset_id"
+	// maxCountIDs is the maximum number of posts whose comments can be counted at once.
+	maxCountIDs int = 100
+	// pageSize is the default number of top-level comments on a page, maxPageSize is the largest allowed.
+	pageSize    int = 20
+	maxPageSize int = 100
+	// adminTokenHeader carries the token of administrative requests.
+	adminTokenHeader string = "X-Admin-Token"
+	// defaultReportThreshold is the default number of reports that hides a comment.
+	defaultReportThreshold int = 3
+	// fingerprintHeader identifies the anonymous client of a request, it is set by the gateway.
+	fingerprintHeader string = "X-Client-Fingerprint"
+	// idempotencyHeader carries the idempotency key of a new comment, chosen by the client;
+	// replayedHeader marks the response to a retry that didn't add the comment again.
+	idempotencyHeader string = "Idempotency-Key"
+	replayedHeader    string = "Idempotent-Replayed"
+	// maxIdempotencyKey is the maximum length of an idempotency key.
+	maxIdempotencyKey int = 255
 )
 
 // API is the API struct
 type API struct {
 	r  *mux.Router
 	db *db.DB
+	// adminToken authorizes administrative requests, they are refused if it is empty.
+	adminToken string
+	// principalSecret verifies the principals sent by the gateway, see parsePrincipal.
+	principalSecret []byte
+	// reportThreshold is the number of reports that hides a comment.
+	reportThreshold int
 }
 
-// New creates a new API
+// New creates a new API.
+// The token of administrative requests is taken from the admin_token environment variable,
+// the secret of the principals sent by the gateway from the principal_secret environment variable,
+// the number of reports that hides a comment from the report_threshold environment variable, defaultReportThreshold by default.
 func New(db *db.DB) *API {
 	api := API{}
 	api.db = db
+	api.adminToken = os.Getenv("admin_token")
+	api.principalSecret = []byte(os.Getenv("principal_secret"))
+	api.reportThreshold = defaultReportThreshold
+	if n, err := strconv.Atoi(os.Getenv("report_threshold")); err == nil && n > 0 {
+		api.reportThreshold = n
+	}
 	api.r = mux.NewRouter()
 	api.endpoints()
 	return &api
@@ -44,8 +80,26 @@ func (api *API) endpoints() {
 	api.r.Use(logMiddleware)
 	api.r.HandleFunc("/comments", api.comments).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/comments", api.addComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/comments/activity", api.activity).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/count", api.countComments).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/top", api.topComments).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/replies", api.replies).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/comments/{id}", api.updateComment).Methods(http.MethodPut, http.MethodOptions)
 	api.r.HandleFunc("/comments/{id}", api.deleteComment).Methods(http.MethodDelete, http.MethodOptions)
+	api.r.HandleFunc("/comments/reported", api.reportedComments).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/report", api.report).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/vote", api.vote).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/versions", api.versions).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/purge", api.purgeComment).Methods(http.MethodDelete, http.MethodOptions)
+	api.r.HandleFunc("/comments/moderation", api.pendingComments).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/approve", api.approveComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}/reject", api.rejectComment).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/comments/{id}", api.comment).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/users", api.addUser).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/users/{id}", api.user).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/users/login", api.login).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/users/{id}", api.updateUser).Methods(http.MethodPut, http.MethodOptions)
+	api.r.HandleFunc("/users/{id}/role", api.setRole).Methods(http.MethodPut, http.MethodOptions)
 }
 
 // headersMiddleware sets the Content-Type to application/json and Access-Control-Allow-Origin to *.
@@ -57,7 +111,7 @@ func headersMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+adminTokenHeader+", "+principalHeader+", "+idempotencyHeader)
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -89,38 +143,166 @@ func logMiddleware(next http.Handler) http.Handler {
 
 // addComment adds a new comment to the database.
 // The request body should contain a valid Comment struct.
-// If the request body is invalid, it returns a 400 Bad Request status.
+// The AddTime of the comment is assigned by the server, the one sent by the client is ignored.
+// The Status of the comment is either approved or pending, the default, if it waits for a moderator:
+// the gateway sets it from the verdict of the cenzor service.
+// The author of the comment is the user authenticated by the gateway, see authenticate;
+// comments without the principal header are anonymous.
+// A retry with the same Idempotency-Key header doesn't add the comment again, see db.AddCommentOnce;
+// the keys are scoped to the user or, for anonymous comments, to the client fingerprint.
+// If the request body or the key is invalid, it returns a 400 Bad Request status.
+// If the principal header is not valid, it returns a 401 Unauthorized status.
+// If the parent comment doesn't exist, isn't approved or belongs to another post,
+// or the key is already used for another comment, it returns a 422 Unprocessable Entity status.
 // If there is an error when adding the comment, it returns a 500 Internal Server Error status.
-// Otherwise, it returns a 200 OK status and a JSON response containing the ID of the newly added comment.
+// If the comment was already added with the key, it returns a 200 OK status with the
+// Idempotent-Replayed header, the Location of the comment and a JSON response containing it.
+// Otherwise, it returns a 201 Created status with the Location of the comment, see comment,
+// and a JSON response containing the stored comment with its ID.
 func (api *API) addComment(w http.ResponseWriter, r *http.Request) {
 	var c models.Comment
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
-	// id, err := api.db.AddComment(c)
-	_, err := api.db.AddComment(c)
+	if c.PostID <= 0 {
+		http.Error(w, "Invalid post ID.", http.StatusBadRequest)
+		return
+	}
+	if c.Status != "" && c.Status != db.StatusPending && c.Status != db.StatusApproved {
+		http.Error(w, fmt.Sprintf("Invalid status %q", c.Status), http.StatusBadRequest)
+		return
+	}
+	author, err := api.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c.AuthorID = author.ID
+	c.AddTime = time.Now().Unix()
+	key, scope := r.Header.Get(idempotencyHeader), ""
+	if len(key) > maxIdempotencyKey {
+		http.Error(w, fmt.Sprintf("Invalid %s: longer than %d characters", idempotencyHeader, maxIdempotencyKey), http.StatusBadRequest)
+		return
+	}
+	if key != "" {
+		var ok bool
+		if scope, ok = api.clientKey(w, r); !ok {
+			return
+		}
+	}
+	id, added, err := api.db.AddCommentOnce(c, scope, key)
+	if isReferenceError(err) {
+		http.Error(w, fmt.Sprintf("Invalid parent comment. Error: %s", err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, db.ErrKeyReused) {
+		http.Error(w, fmt.Sprintf("Invalid %s. Error: %s", idempotencyHeader, err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to add comment. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	// json.NewEncoder(w).Encode(map[string]int{"id": id})
+	c, err = api.db.Comment(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/comments/"+strconv.Itoa(id))
+	if added {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.Header().Set(replayedHeader, "true")
+	}
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode comment. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
 }
 
+// comment returns a comment by its ID, taken from the URL.
+// Approved comments are public, pending and rejected ones are shown
+// only to their author and to moderators, see isModerator.
+// If the ID is invalid, it returns a 400 Bad Request status.
+// If there is no such comment or it is hidden from the user, it returns a 404 Not Found status.
+// If there is an error when getting the comment, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the comment.
+func (api *API) comment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	c, err := api.db.Comment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if c.Status != db.StatusApproved && !api.isModerator(r) {
+		p, err := api.authenticate(r)
+		if err != nil || p.ID == 0 || p.ID != c.AuthorID {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode comment. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
 
-// updateComment updates an existing comment in the database.
-// The request body should contain a valid Comment struct.
-// If the request body is invalid, it returns a 400 Bad Request status.
+
+// updateComment edits an existing comment in the database.
+// The ID of the comment is taken from the URL, the request body should contain a valid Comment struct.
+// The previous content is kept in the edit history of the comment, see versions.
+// Only the author of the comment or a moderator can edit it, see canModify.
+// If the ID or the request body is invalid, it returns a 400 Bad Request status.
+// An edit can send the comment back to moderation with the pending Status, but never approves it, see db.UpdateComment.
+// If the edit moves the comment to another post or parent, it returns a 422 Unprocessable Entity status.
+// If the comment is rejected, it returns a 409 Conflict status.
+// If there is no such comment or it is deleted, it returns a 404 Not Found status.
 // If there is an error when updating the comment, it returns a 500 Internal Server Error status.
 // Otherwise, it returns a 204 No Content status.
 func (api *API) updateComment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !api.canModify(w, r, id) {
+		return
+	}
 	var c models.Comment
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
-	if err := api.db.UpdateComment(c); err != nil {
+	if c.PostID <= 0 {
+		http.Error(w, "Invalid post ID.", http.StatusBadRequest)
+		return
+	}
+	if c.Status != "" && c.Status != db.StatusPending && c.Status != db.StatusApproved {
+		http.Error(w, fmt.Sprintf("Invalid status %q", c.Status), http.StatusBadRequest)
+		return
+	}
+	c.ID = id
+	err = api.db.UpdateComment(c)
+	if errors.Is(err, db.ErrMoved) {
+		http.Error(w, fmt.Sprintf("Invalid edit. Error: %s", err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, db.ErrRejected) {
+		http.Error(w, fmt.Sprintf("Invalid edit. Error: %s", err.Error()), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update comment. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
@@ -128,9 +310,13 @@ func (api *API) updateComment(w http.ResponseWriter, r *http.Request) {
 }
 
 
-// deleteComment deletes a comment from the database.
+// deleteComment marks a comment as deleted.
+// The content of the comment is blanked, but it stays in the tree
+// as a "[deleted]" placeholder, so its replies are kept.
 // The comment ID is passed as a URL parameter "id".
+// Only the author of the comment or a moderator can delete it, see canModify.
 // If the comment ID is invalid, it returns a 400 Bad Request status.
+// If there is no such comment, it returns a 404 Not Found status.
 // If there is an error when deleting the comment, it returns a 500 Internal Server Error status.
 // Otherwise, it returns a 204 No Content status.
 func (api *API) deleteComment(w http.ResponseWriter, r *http.Request) {
@@ -140,17 +326,58 @@ func (api *API) deleteComment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
-	if err := api.db.DeleteComment(id); err != nil {
+	if !api.canModify(w, r, id) {
+		return
+	}
+	err = api.db.DeleteComment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete comment. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// purgeComment removes a comment and all its replies from the database.
+// It is an administrative request, see isAdmin.
+// The comment ID is passed as a URL parameter "id".
+// If the request is not administrative, it returns a 403 Forbidden status.
+// If the comment ID is invalid, it returns a 400 Bad Request status.
+// If there is no such comment, it returns a 404 Not Found status.
+// If there is an error when removing the comments, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the number of removed comments.
+func (api *API) purgeComment(w http.ResponseWriter, r *http.Request) {
+	if !api.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	n, err := api.db.PurgeComment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to purge comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]int{"removed": n}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
 
 // comments returns a list of comments for the given post ID.
-// The post ID is passed as a query parameter "id_post".
-// If the post ID is invalid, it returns a 400 Bad Request status.
+// The post ID is passed as a query parameter "id_post",
+// the optional query parameter "sort" sets the order of the replies, see parseSort.
+// If the post ID or the sort mode is invalid, it returns a 400 Bad Request status.
 // If there is an error when retrieving the comments, it returns a 500 Internal Server Error status.
 // Otherwise, it returns a 200 OK status and a JSON response containing the list of comments.
 func (api *API) comments(w http.ResponseWriter, r *http.Request) {
@@ -160,7 +387,11 @@ func (api *API) comments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Invalid post ID. Error: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
-	comments, err := api.db.Comments(id)
+	sort, ok := parseSort(w, r)
+	if !ok {
+		return
+	}
+	comments, err := api.db.Comments(id, sort)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get comments. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
@@ -169,3 +400,474 @@ func (api *API) comments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to encode comments. Error: %s", err.Error()), http.StatusInternalServerError)
 	}
 }
+
+
+// activity returns the comment activity of the posts commented since the given time.
+// The time is passed as a Unix time in the query parameter "since".
+// If the time is invalid, it returns a 400 Bad Request status.
+// If there is an error when retrieving the activity, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the number of all comments
+// and of the comments added since the given time for each post.
+func (api *API) activity(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid time. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	activity, err := api.db.Activity(since)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get activity. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if activity == nil {
+		activity = []models.Activity{}
+	}
+	if err := json.NewEncoder(w).Encode(activity); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode activity. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// countComments returns the number of comments of several posts at once.
+// The post IDs are passed as a comma-separated query parameter "ids", e.g. ids=1,2,3,
+// at most maxCountIDs of them.
+// If the IDs are invalid, it returns a 400 Bad Request status.
+// If there is an error when counting the comments, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON object mapping each post ID to its number of comments.
+func (api *API) countComments(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid post IDs. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxCountIDs {
+		http.Error(w, fmt.Sprintf("Too many post IDs, at most %d are allowed", maxCountIDs), http.StatusBadRequest)
+		return
+	}
+	counts, err := api.db.CountComments(ids)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count comments. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(counts); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode counts. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// isReferenceError reports whether the error is a rejected reference to a parent comment.
+func isReferenceError(err error) bool {
+	return errors.Is(err, db.ErrParentNotFound) || errors.Is(err, db.ErrParentOnOtherPost)
+}
+
+// parseIDs parses a comma-separated list of IDs, dropping duplicates.
+func parseIDs(str string) ([]int, error) {
+	if str == "" {
+		return nil, errors.New("empty list of IDs")
+	}
+	var ids []int
+	seen := make(map[int]bool)
+	for _, s := range strings.Split(str, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// topComments returns a page of the top-level comments of a post without their replies,
+// each with its number of replies, so large threads can be loaded piece by piece.
+// The post ID is passed as a query parameter "id_post". The optional query parameters are
+// "sort" (see parseSort),
+// "limit" (the page size, pageSize by default, at most maxPageSize)
+// and "cursor" (the NextCursor of the previous page).
+// If a parameter is invalid, it returns a 400 Bad Request status.
+// If there is an error when retrieving the comments, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the page of comments
+// and the cursor of the next page, which is empty on the last page.
+func (api *API) topComments(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(r.URL.Query().Get("id_post"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid post ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	sort, ok := parseSort(w, r)
+	if !ok {
+		return
+	}
+	limit := pageSize
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit, it must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	var after *db.Cursor
+	if str := r.URL.Query().Get("cursor"); str != "" {
+		after, err = decodeCursor(str)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid cursor. Error: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	comments, err := api.db.TopComments(postID, sort, after, limit+1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get comments. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	page := models.CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = encodeCursor(sort, page.Comments[limit-1])
+	}
+	if page.Comments == nil {
+		page.Comments = []models.Comment{}
+	}
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode comments. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// replies returns the subtree of replies of a comment.
+// The comment ID is passed as a URL parameter "id",
+// the optional query parameter "sort" sets the order of the replies, see parseSort.
+// If the comment ID or the sort mode is invalid, it returns a 400 Bad Request status.
+// If there is no such approved comment that is not deleted, it returns a 404 Not Found status;
+// moderators, see isModerator, get the replies of every comment.
+// If there is an error when retrieving the replies, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the direct replies
+// of the comment with their own replies.
+func (api *API) replies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	sort, ok := parseSort(w, r)
+	if !ok {
+		return
+	}
+	replies, err := api.db.Replies(id, sort, api.isModerator(r))
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get replies. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if replies == nil {
+		replies = []models.Comment{}
+	}
+	if err := json.NewEncoder(w).Encode(replies); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode replies. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// versions returns the edit history of a comment, the newest version first.
+// The history of pending, rejected and deleted comments is shown only to moderators, see isModerator.
+// If the ID is invalid, it returns a 400 Bad Request status.
+// If there is an error when getting the versions, it returns a 500 Internal Server Error status.
+func (api *API) versions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	versions, err := api.db.Versions(id, api.isModerator(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get versions. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []models.CommentVersion{}
+	}
+	if err := json.NewEncoder(w).Encode(versions); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode versions. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// parseSort returns the sort mode from the query parameter "sort": oldest, the default,
+// newest, replies for the most replied first or score for the highest score first.
+// If the sort mode is invalid, it writes a 400 Bad Request status.
+func parseSort(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sort := r.URL.Query().Get("sort")
+	switch sort {
+	case "":
+		return db.SortOldest, true
+	case db.SortOldest, db.SortNewest, db.SortReplies, db.SortScore:
+		return sort, true
+	}
+	http.Error(w, fmt.Sprintf("Invalid sort mode %q", sort), http.StatusBadRequest)
+	return "", false
+}
+
+// vote sets the vote of the client on a comment.
+// The comment ID is passed as a URL parameter "id", the request body holds the vote:
+// {"Value": 1} for an upvote, {"Value": -1} for a downvote or {"Value": 0} to take the vote back.
+// Each voter, see clientKey, has one vote per comment.
+// If the comment ID or the vote is invalid, it returns a 400 Bad Request status.
+// If there is no such approved comment, it returns a 404 Not Found status.
+// If there is an error when voting, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the new Score of the comment.
+func (api *API) vote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Value int
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value < -1 || body.Value > 1 {
+		http.Error(w, "Invalid request payload: the value of the vote must be 1, -1 or 0", http.StatusBadRequest)
+		return
+	}
+	voter, ok := api.clientKey(w, r)
+	if !ok {
+		return
+	}
+	score, err := api.db.Vote(id, voter, body.Value)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to vote. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]int{"Score": score}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// report reports an abusive comment to the moderators.
+// The comment ID is passed as a URL parameter "id", the request body holds the reason of the report:
+// {"Reason": "spam"}, "abuse", "offtopic" or "other".
+// Each reporter, see clientKey, can report a comment once. When the comment gets
+// reportThreshold reports of users, it is hidden until a moderator approves it, see reportedComments.
+// Anonymous reports are shown to moderators, but don't hide the comment, see db.Report.
+// If the comment ID or the reason is invalid, it returns a 400 Bad Request status.
+// If there is no such comment, it returns a 404 Not Found status.
+// If the reporter has already reported the comment, it returns a 409 Conflict status.
+// If there is an error when reporting, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 204 No Content status.
+func (api *API) report(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Reason string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	switch body.Reason {
+	case db.ReasonSpam, db.ReasonAbuse, db.ReasonOfftopic, db.ReasonOther:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid reason %q", body.Reason), http.StatusBadRequest)
+		return
+	}
+	reporter, ok := api.clientKey(w, r)
+	if !ok {
+		return
+	}
+	hidden, err := api.db.Report(id, reporter, body.Reason, api.reportThreshold)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, db.ErrAlreadyReported) {
+		http.Error(w, "Comment is already reported", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to report comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if hidden {
+		log.Printf("Comment %d is hidden after %d reports", id, api.reportThreshold)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientKey identifies the client of a request for votes and reports:
+// "user:<id>" for an authenticated user, see authenticate, or else "client:<fingerprint>"
+// for an anonymous client identified by the X-Client-Fingerprint header set by the gateway.
+// If the principal header is not valid, it writes a 401 Unauthorized status,
+// if the client can't be identified, it writes a 400 Bad Request status.
+func (api *API) clientKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	p, err := api.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if p.ID != 0 {
+		return db.UserReporter + strconv.Itoa(p.ID), true
+	}
+	if fp := r.Header.Get(fingerprintHeader); fp != "" {
+		return "client:" + fp, true
+	}
+	http.Error(w, "Unknown client: authenticate or send the "+fingerprintHeader+" header", http.StatusBadRequest)
+	return "", false
+}
+
+// encodeCursor returns the opaque cursor pointing at the comment in the given sort mode.
+func encodeCursor(sort string, c models.Comment) string {
+	key := c.AddTime
+	switch sort {
+	case db.SortReplies:
+		key = int64(c.ReplyCount)
+	case db.SortScore:
+		key = int64(c.Score)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", key, c.ID)))
+}
+
+// decodeCursor parses a cursor returned by encodeCursor.
+func decodeCursor(str string) (*db.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	var c db.Cursor
+	if _, err := fmt.Sscanf(string(b), "%d:%d", &c.Key, &c.ID); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// pendingComments returns the moderation queue: the comments waiting for a moderator, the oldest first.
+// It is a moderator request, see isModerator.
+// The optional query parameter "limit" sets the number of comments, pageSize by default, at most maxPageSize.
+// If the request is not from a moderator, it returns a 403 Forbidden status.
+// If the limit is invalid, it returns a 400 Bad Request status.
+// If there is an error when retrieving the comments, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the list of comments.
+func (api *API) pendingComments(w http.ResponseWriter, r *http.Request) {
+	if !api.isModerator(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	limit := pageSize
+	if str := r.URL.Query().Get("limit"); str != "" {
+		var err error
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit, it must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	comments, err := api.db.PendingComments(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get pending comments. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if comments == nil {
+		comments = []models.Comment{}
+	}
+	if err := json.NewEncoder(w).Encode(comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode comments. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// reportedComments returns the moderator view of the reports: the comments reported by readers,
+// the most reported first, with the number of reports for each reason.
+// It is a moderator request, see isModerator.
+// The optional query parameter "limit" sets the number of comments, pageSize by default, at most maxPageSize.
+// If the request is not from a moderator, it returns a 403 Forbidden status.
+// If the limit is invalid, it returns a 400 Bad Request status.
+// If there is an error when retrieving the comments, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the list of comments.
+func (api *API) reportedComments(w http.ResponseWriter, r *http.Request) {
+	if !api.isModerator(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	limit := pageSize
+	if str := r.URL.Query().Get("limit"); str != "" {
+		var err error
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit, it must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	comments, err := api.db.ReportedComments(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get reported comments. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if comments == nil {
+		comments = []models.ReportedComment{}
+	}
+	if err := json.NewEncoder(w).Encode(comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode comments. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// approveComment approves a comment, so it is shown under its post.
+// The reports on the comment are dismissed.
+// It is a moderator request, see isModerator.
+// The comment ID is passed as a URL parameter "id".
+// It returns a 204 No Content status on success, see moderate for the errors.
+func (api *API) approveComment(w http.ResponseWriter, r *http.Request) {
+	api.moderate(w, r, db.StatusApproved, "")
+}
+
+// rejectComment rejects a comment, so it is not shown.
+// It is a moderator request, see isModerator.
+// The comment ID is passed as a URL parameter "id", the request body is a JSON object
+// with the reason of the rejection, e.g. {"Reason": "spam"}.
+// If the request body is invalid or the reason is empty, it returns a 400 Bad Request status.
+// It returns a 204 No Content status on success, see moderate for the other errors.
+func (api *API) rejectComment(w http.ResponseWriter, r *http.Request) {
+	if !api.isModerator(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var body struct {
+		Reason string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Reason) == "" {
+		http.Error(w, "Invalid request payload: the reason of the rejection is required", http.StatusBadRequest)
+		return
+	}
+	api.moderate(w, r, db.StatusRejected, strings.TrimSpace(body.Reason))
+}
+
+// moderate sets the moderation status of the comment with the ID from the URL parameter "id".
+// If the request is not from a moderator, it returns a 403 Forbidden status.
+// If the comment ID is invalid, it returns a 400 Bad Request status.
+// If there is no such comment, it returns a 404 Not Found status.
+// If there is an error when updating the comment, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 204 No Content status.
+func (api *API) moderate(w http.ResponseWriter, r *http.Request, status string, reason string) {
+	if !api.isModerator(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid comment ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	err = api.db.Moderate(id, status, reason)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to moderate comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
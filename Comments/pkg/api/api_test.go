@@ -4,10 +4,14 @@ import (
 	"Comments/pkg/db"
 	"Comments/pkg/models"
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -32,6 +36,7 @@ func TestAddComment(t *testing.T) {
 		ParentID: 0,
 		Content:  "test comment",
 		AddTime:  time.Now().Unix(),
+		Status:   "approved",
 	}
 
 	reqBody, _ := json.Marshal(newComment)
@@ -42,13 +47,24 @@ func TestAddComment(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 
-	var resp map[string]int
+	var resp models.Comment
 	err := json.NewDecoder(w.Body).Decode(&resp)
 	assert.NoError(t, err)
-	assert.NotZero(t, resp["id"])
+	assert.NotZero(t, resp.ID)
+	assert.Equal(t, "/comments/"+strconv.Itoa(resp.ID), w.Header().Get("Location"))
+	assert.Equal(t, newComment.Content, resp.Content)
+	assert.Equal(t, "approved", resp.Status)
+
+	req = httptest.NewRequest(http.MethodGet, w.Header().Get("Location"), nil)
+	w = httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
 }
 
 func TestUpdateComment(t *testing.T) {
+	os.Setenv("admin_token", "secret")
 	api := setupAPI(t)
 
 	updatedComment := models.Comment{
@@ -61,6 +77,7 @@ func TestUpdateComment(t *testing.T) {
 
 	reqBody, _ := json.Marshal(updatedComment)
 	req := httptest.NewRequest(http.MethodPut, "/comments/1", bytes.NewReader(reqBody))
+	req.Header.Set("X-Admin-Token", "secret")
 	w := httptest.NewRecorder()
 
 	api.Router().ServeHTTP(w, req)
@@ -69,9 +86,11 @@ func TestUpdateComment(t *testing.T) {
 }
 
 func TestDeleteComment(t *testing.T) {
+	os.Setenv("admin_token", "secret")
 	api := setupAPI(t)
 
 	req := httptest.NewRequest(http.MethodDelete, "/comments/1", nil)
+	req.Header.Set("X-Admin-Token", "secret")
 	w := httptest.NewRecorder()
 
 	api.Router().ServeHTTP(w, req)
@@ -113,3 +132,167 @@ func TestDeleteCommentInvalidID(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestUpdateCommentInvalidID(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodPut, "/comments/invalid_id", bytes.NewReader([]byte(`{"PostID": 1}`)))
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestActivityInvalidTime(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/activity?since=yesterday", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCountCommentsInvalidIDs(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/count?ids=1,two", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTopCommentsInvalidSort(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/top?id_post=1&sort=random", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCursor(t *testing.T) {
+	c := models.Comment{ID: 7, AddTime: 1700000000, ReplyCount: 3}
+
+	got, err := decodeCursor(encodeCursor(db.SortNewest, c))
+	assert.NoError(t, err)
+	assert.Equal(t, &db.Cursor{Key: 1700000000, ID: 7}, got)
+
+	got, err = decodeCursor(encodeCursor(db.SortReplies, c))
+	assert.NoError(t, err)
+	assert.Equal(t, &db.Cursor{Key: 3, ID: 7}, got)
+
+	_, err = decodeCursor("not a cursor")
+	assert.Error(t, err)
+}
+
+func TestAddCommentUnknownParent(t *testing.T) {
+	api := setupAPI(t)
+
+	reqBody, _ := json.Marshal(models.Comment{
+		PostID:   1,
+		ParentID: 1_000_000,
+		Content:  "reply to nothing",
+		AddTime:  time.Now().Unix(),
+	})
+	req := httptest.NewRequest(http.MethodPost, "/comments", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestPurgeCommentForbidden(t *testing.T) {
+	os.Setenv("admin_token", "secret")
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/1/purge", nil)
+	req.Header.Set("X-Admin-Token", "guess")
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPendingCommentsForbidden(t *testing.T) {
+	os.Setenv("admin_token", "secret")
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/moderation", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestDeleteCommentUnauthorized(t *testing.T) {
+	os.Setenv("admin_token", "secret")
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/1", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAddUserInvalidName(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader([]byte(`{"Name": "a b", "Password": "long enough"}`)))
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestReportInvalidReason(t *testing.T) {
+	api := setupAPI(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/1/report", bytes.NewReader([]byte(`{"Reason": "boring"}`)))
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestParsePrincipal(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	sign := func(p principal, secret []byte) string {
+		data, _ := json.Marshal(p)
+		payload := base64.RawURLEncoding.EncodeToString(data)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(payload))
+		return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	want := principal{ID: 7, Name: "reader", Role: db.RoleModerator, Exp: now.Unix() + 60}
+
+	got, err := parsePrincipal(sign(want, secret), secret, now)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = parsePrincipal(sign(want, []byte("guess")), secret, now)
+	assert.ErrorIs(t, err, errInvalidPrincipal, "A forged principal should be refused")
+
+	_, err = parsePrincipal(sign(want, secret), secret, now.Add(time.Minute))
+	assert.ErrorIs(t, err, errInvalidPrincipal, "An expired principal should be refused")
+
+	_, err = parsePrincipal(sign(want, nil), nil, now)
+	assert.ErrorIs(t, err, errInvalidPrincipal, "Principals should be refused without a secret")
+
+	_, err = parsePrincipal("garbage", secret, now)
+	assert.ErrorIs(t, err, errInvalidPrincipal)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// principalHeader carries the user authenticated by the gateway.
+const principalHeader string = "X-Principal"
+
+// errInvalidPrincipal is returned when the principal header is malformed, forged or expired.
+var errInvalidPrincipal = errors.New("invalid principal")
+
+// principal is the user authenticated by the gateway.
+// Exp is the time the principal expires, the expiration time of the access token of the user.
+type principal struct {
+	ID   int    `json:"ID"`
+	Name string `json:"Name"`
+	Role string `json:"Role"`
+	Exp  int64  `json:"Exp"`
+}
+
+// parsePrincipal verifies and decodes the principal header.
+// The header is the base64url-encoded JSON of the principal and its HMAC-SHA256
+// signature with the secret shared with the gateway, separated by a dot.
+// Principals are refused if the secret is empty.
+func parsePrincipal(header string, secret []byte, now time.Time) (principal, error) {
+	if len(secret) == 0 {
+		return principal{}, errInvalidPrincipal
+	}
+	payload, sig, ok := strings.Cut(header, ".")
+	if !ok {
+		return principal{}, errInvalidPrincipal
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return principal{}, errInvalidPrincipal
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return principal{}, errInvalidPrincipal
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return principal{}, errInvalidPrincipal
+	}
+	var p principal
+	if err := json.Unmarshal(data, &p); err != nil || p.ID <= 0 {
+		return principal{}, errInvalidPrincipal
+	}
+	if now.Unix() >= p.Exp {
+		return principal{}, errInvalidPrincipal
+	}
+	return p, nil
+}
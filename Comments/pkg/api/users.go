@@ -0,0 +1,322 @@
+package api
+
+import (
+	"Comments/pkg/db"
+	"Comments/pkg/models"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// minPasswordLength is the shortest allowed password, maxPasswordLength is
+	// the longest one, bcrypt ignores the bytes after it.
+	minPasswordLength int = 8
+	maxPasswordLength int = 72
+	// maxAboutLength is the longest allowed "about" text of a profile, in characters.
+	maxAboutLength int = 1000
+)
+
+// userName is the pattern of user names: 3 to 32 letters, digits, dots, dashes or underscores.
+var userName = regexp.MustCompile(`^[\p{L}\p{N}_.-]{3,32}$`)
+
+// errUnauthorized is the error of a login with a wrong name or password.
+var errUnauthorized = errors.New("invalid user name or password")
+
+// registration is the request body of a new user.
+type registration struct {
+	Name     string `json:"Name"`
+	Password string `json:"Password"`
+	About    string `json:"About"`
+}
+
+// addUser registers a new user.
+// The request body should contain the Name, the Password and optionally the About text of the user.
+// The password is stored as a bcrypt hash.
+// If the request body is invalid, it returns a 400 Bad Request status.
+// If the name is taken, it returns a 409 Conflict status.
+// If there is an error when adding the user, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 201 Created status with the Location of the profile
+// and a JSON response containing the profile.
+func (api *API) addUser(w http.ResponseWriter, r *http.Request) {
+	var reg registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !userName.MatchString(reg.Name) {
+		http.Error(w, "Invalid user name: use 3 to 32 letters, digits, dots, dashes or underscores.", http.StatusBadRequest)
+		return
+	}
+	if len(reg.Password) < minPasswordLength || len(reg.Password) > maxPasswordLength {
+		http.Error(w, fmt.Sprintf("Invalid password: use %d to %d bytes.", minPasswordLength, maxPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(reg.About) > maxAboutLength {
+		http.Error(w, fmt.Sprintf("About is longer than %d characters.", maxAboutLength), http.StatusBadRequest)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(reg.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to hash password. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	u := models.User{Name: reg.Name, About: reg.About, CreatedAt: time.Now().Unix()}
+	u.ID, err = api.db.AddUser(u, string(hash))
+	if errors.Is(err, db.ErrUserExists) {
+		http.Error(w, "User name is taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add user. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/users/"+strconv.Itoa(u.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode user. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// user returns the profile of a user.
+// The user ID is passed as a URL parameter "id".
+// If the user ID is invalid, it returns a 400 Bad Request status.
+// If there is no such user, it returns a 404 Not Found status.
+// If there is an error when getting the user, it returns a 500 Internal Server Error status.
+func (api *API) user(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid user ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	u, err := api.db.User(id)
+	if errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get user. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode user. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// updateUser updates the About text of a user profile.
+// The user ID is passed as a URL parameter "id", the request body should contain the About text.
+// Only the user themselves can update the profile, see authenticate.
+// If the user ID or the request body is invalid, it returns a 400 Bad Request status.
+// If the request is not authenticated, it returns a 401 Unauthorized status.
+// If the request is authenticated as another user, it returns a 403 Forbidden status.
+// If there is an error when updating the user, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 204 No Content status.
+func (api *API) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid user ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	userID, ok := api.requireUser(w, r)
+	if !ok {
+		return
+	}
+	if userID != id {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var u models.User
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(u.About) > maxAboutLength {
+		http.Error(w, fmt.Sprintf("About is longer than %d characters.", maxAboutLength), http.StatusBadRequest)
+		return
+	}
+	u.ID = id
+	if err := api.db.UpdateUser(u); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update user. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// login checks the name and the password of a user.
+// The request body should contain the Name and the Password of the user.
+// It is used by the gateway to issue access tokens.
+// If the request body is invalid, it returns a 400 Bad Request status.
+// If the name or the password is wrong, it returns a 401 Unauthorized status.
+// If there is an error when checking the password, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 200 OK status and a JSON response containing the profile with the role of the user.
+func (api *API) login(w http.ResponseWriter, r *http.Request) {
+	var reg registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	id, hash, err := api.db.PasswordHash(reg.Name)
+	if errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check password. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(reg.Password)) != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	u, err := api.db.User(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get user. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode user. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// setRole changes the role of a user to reader, moderator or admin.
+// It is an administrative request, see isAdmin.
+// The user ID is passed as a URL parameter "id", the request body holds the role, e.g. {"Role": "moderator"}.
+// The new role takes effect when the user gets a new access token.
+// If the request is not administrative, it returns a 403 Forbidden status.
+// If the user ID or the role is invalid, it returns a 400 Bad Request status.
+// If there is no such user, it returns a 404 Not Found status.
+// If there is an error when updating the user, it returns a 500 Internal Server Error status.
+// Otherwise, it returns a 204 No Content status.
+func (api *API) setRole(w http.ResponseWriter, r *http.Request) {
+	if !api.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid user ID. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	var u models.User
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload. Error: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if u.Role != db.RoleReader && u.Role != db.RoleModerator && u.Role != db.RoleAdmin {
+		http.Error(w, fmt.Sprintf("Invalid role %q", u.Role), http.StatusBadRequest)
+		return
+	}
+	err = api.db.SetRole(id, u.Role)
+	if errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update user. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate returns the user of the request, authenticated by the gateway
+// and sent in the signed principal header, see parsePrincipal.
+// It returns a zero principal for anonymous requests without the header
+// and errInvalidPrincipal if the header is not valid.
+func (api *API) authenticate(r *http.Request) (principal, error) {
+	header := r.Header.Get(principalHeader)
+	if header == "" {
+		return principal{}, nil
+	}
+	return parsePrincipal(header, api.principalSecret, time.Now())
+}
+
+// requireUser returns the ID of the authenticated user of the request, see authenticate.
+// If the request is anonymous or its principal is not valid, it writes a 401 Unauthorized status.
+func (api *API) requireUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	p, err := api.authenticate(r)
+	if err != nil || p.ID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	return p.ID, true
+}
+
+// role returns the role of the authenticated user of the request, see authenticate,
+// or an empty string for anonymous requests and invalid principals.
+// The role in the principal was taken when the access token was issued,
+// so a privileged role is checked against the database: a user who has been
+// demoted since then gets their current role.
+func (api *API) role(r *http.Request) string {
+	p, err := api.authenticate(r)
+	if err != nil || p.ID == 0 {
+		return ""
+	}
+	if p.Role != db.RoleAdmin && p.Role != db.RoleModerator {
+		return p.Role
+	}
+	role, err := api.db.UserRole(p.ID)
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// hasAdminToken reports whether the request carries the admin token.
+func (api *API) hasAdminToken(r *http.Request) bool {
+	token := r.Header.Get(adminTokenHeader)
+	return api.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(api.adminToken)) == 1
+}
+
+// isAdmin reports whether the request is administrative:
+// it carries the admin token or comes from a user with the admin role, see role.
+func (api *API) isAdmin(r *http.Request) bool {
+	return api.hasAdminToken(r) || api.role(r) == db.RoleAdmin
+}
+
+// isModerator reports whether the request may moderate comments:
+// it carries the admin token or comes from a user with the moderator or admin role, see role.
+func (api *API) isModerator(r *http.Request) bool {
+	if api.hasAdminToken(r) {
+		return true
+	}
+	role := api.role(r)
+	return role == db.RoleModerator || role == db.RoleAdmin
+}
+
+// canModify reports whether the request may edit or delete the comment:
+// only its author and moderators, see isModerator, can do it.
+// Otherwise, it writes a 401 Unauthorized status for anonymous requests,
+// a 403 Forbidden status for other users or anonymous comments,
+// or a 404 Not Found status if there is no such comment.
+func (api *API) canModify(w http.ResponseWriter, r *http.Request, id int) bool {
+	if api.isModerator(r) {
+		return true
+	}
+	userID, ok := api.requireUser(w, r)
+	if !ok {
+		return false
+	}
+	authorID, err := api.db.CommentAuthor(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get comment. Error: %s", err.Error()), http.StatusInternalServerError)
+		return false
+	}
+	if authorID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -1,15 +1,104 @@
 package db
 
 import (
+	"Comments/pkg/migrate"
 	"Comments/pkg/models"
 	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"sort"
+	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/subosito/gotenv"
 )
 
+// migrations holds the versioned schema changes applied by Migrate.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Errors returned when a comment or its parent is not valid.
+var (
+	// ErrNotFound is returned when the comment doesn't exist.
+	ErrNotFound = errors.New("comment not found")
+	// ErrParentNotFound is returned when the parent comment doesn't exist.
+	ErrParentNotFound = errors.New("parent comment not found")
+	// ErrParentOnOtherPost is returned when the parent comment belongs to another post.
+	ErrParentOnOtherPost = errors.New("parent comment belongs to another post")
+	// ErrMoved is returned when an edit changes the post or the parent of a comment.
+	ErrMoved = errors.New("comment can't be moved to another post or parent")
+	// ErrRejected is returned when a rejected comment is edited.
+	ErrRejected = errors.New("rejected comment can't be edited")
+	// ErrKeyReused is returned when an idempotency key is reused for another comment.
+	ErrKeyReused = errors.New("idempotency key is already used for another comment")
+	// ErrAlreadyReported is returned when a reporter reports a comment twice.
+	ErrAlreadyReported = errors.New("comment is already reported")
+)
+
+// Errors returned when a user is not valid.
+var (
+	// ErrUserNotFound is returned when the user doesn't exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when the name of a new user is taken.
+	ErrUserExists = errors.New("user name is taken")
+)
+
+// DeletedContent replaces the content of deleted comments.
+const DeletedContent = "[deleted]"
+
+// Moderation statuses of comments. Only approved comments are shown,
+// pending ones wait in the moderation queue.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Reasons of the reports on comments.
+const (
+	ReasonSpam     = "spam"
+	ReasonAbuse    = "abuse"
+	ReasonOfftopic = "offtopic"
+	ReasonOther    = "other"
+)
+
+// UserReporter is the prefix of the voters and reporters that are users, followed by the ID of the user.
+const UserReporter = "user:"
+
+// Roles of users. Readers write comments, moderators also moderate
+// the comments of others, admins also manage the roles of users.
+const (
+	RoleReader    = "reader"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// commentColumns lists the columns of a comment in the order expected by scanComment.
+const commentColumns = `id, post_id, COALESCE(parent_id, 0), content, add_time, deleted, status, reject_reason, edited_at, edit_count,
+	COALESCE(author_id, 0), COALESCE((SELECT name FROM users WHERE users.id = author_id), ''), score`
+
+// scanComment scans a row selected with commentColumns into a comment.
+// The content of a deleted comment is replaced with DeletedContent.
+func scanComment(row pgx.Row, extra ...interface{}) (models.Comment, error) {
+	var c models.Comment
+	dest := append([]interface{}{&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.AddTime, &c.Deleted, &c.Status, &c.RejectReason, &c.EditedAt, &c.EditCount, &c.AuthorID, &c.AuthorName, &c.Score}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.Comment{}, err
+	}
+	if c.Deleted {
+		c.Content = DeletedContent
+		c.AuthorName = ""
+	}
+	return c, nil
+}
+
 type DB struct {
 	pool *pgxpool.Pool
 }
@@ -37,31 +126,111 @@ func New() (*DB, error) {
 	return db, nil
 }
 
+// Migrate applies the schema migrations that are not applied yet.
+//
+// Migrate will return the number of applied migrations and an error if any.
+func (db *DB) Migrate() (int, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	return migrate.Up(db.pool, sub)
+}
+
 
 // AddComment adds a comment to the database.
 //
 // AddComment takes a Comment object as argument and will
 // return the generated id of the comment and an error if any.
+// A reply to a comment that doesn't exist or belongs to another post
+// is rejected with ErrParentNotFound or ErrParentOnOtherPost.
+// The parent is checked and the reply is inserted in one transaction,
+// so the parent can't be purged in between.
+// A comment without a moderation status is pending: it is shown only after a moderator approves it.
+// A comment with a zero AuthorID is anonymous.
 func (db *DB) AddComment(c models.Comment) (int, error) {
+	id, _, err := db.AddCommentOnce(c, "", "")
+	return id, err
+}
 
+// AddCommentOnce adds a comment to the database once for an idempotency key, see AddComment.
+// The key is chosen by the client, the scope identifies the client, e.g. "user:<id>".
+// If the key is already used in the scope, the comment added with it is not added again,
+// it is rejected with ErrKeyReused if it differs in its post, parent or content.
+// An empty key adds the comment as AddComment does.
+//
+// AddCommentOnce takes a Comment object, the scope and the key as arguments and will return
+// the id of the new or the previously added comment, whether the comment was added and an error if any.
+func (db *DB) AddCommentOnce(c models.Comment, scope string, key string) (int, bool, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkParent(ctx, tx, c); err != nil {
+		return 0, false, err
+	}
+	if c.Status == "" {
+		c.Status = StatusPending
+	}
+	// the scope, the key and the hash are NULL for comments added without a key
+	var scopeArg, keyArg, hashArg *string
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s", c.PostID, c.ParentID, c.Content)))
+	hash := hex.EncodeToString(sum[:])
+	if key != "" {
+		scopeArg, keyArg, hashArg = &scope, &key, &hash
+	}
 	var id int
-	err := db.pool.QueryRow(context.Background(),
-		"INSERT INTO comments (post_id, parent_id, content, add_time) VALUES ($1, $2, $3, $4) RETURNING id",
-		c.PostID, c.ParentID, c.Content, c.AddTime).Scan(&id)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO comments (post_id, parent_id, content, add_time, status, author_id, idempotency_scope, idempotency_key, idempotency_hash)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8, $9)
+		ON CONFLICT (idempotency_scope, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+		RETURNING id`,
+		c.PostID, c.ParentID, c.Content, c.AddTime, c.Status, c.AuthorID, scopeArg, keyArg, hashArg).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var stored string
+		err = tx.QueryRow(ctx,
+			"SELECT id, idempotency_hash FROM comments WHERE idempotency_scope = $1 AND idempotency_key = $2",
+			scope, key).Scan(&id, &stored)
+		if err != nil {
+			return 0, false, err
+		}
+		if stored != hash {
+			return 0, false, ErrKeyReused
+		}
+		return id, false, nil
+	}
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
-	return id, nil
+	return id, true, tx.Commit(ctx)
 }
 
 
-// Comments retrieves all comments for a post.
+// Comment retrieves a comment of any moderation status.
+//
+// Comment takes a comment ID as argument and will return a Comment object,
+// ErrNotFound if there is no such comment and an error if any.
+func (db *DB) Comment(id int) (models.Comment, error) {
+	c, err := scanComment(db.pool.QueryRow(context.Background(), "SELECT "+commentColumns+" FROM comments WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Comment{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Comment{}, err
+	}
+	return c, nil
+}
+
+// Comments retrieves all approved comments for a post.
 //
-// Comments takes an int as argument and will return a slice of Comment
-// objects and an error if any.
-func (db *DB) Comments(id int) ([]models.Comment, error) {
+// Comments takes a post ID and a sort mode of the replies of each comment as arguments,
+// see buildCommentTree, and will return a slice of Comment objects and an error if any.
+func (db *DB) Comments(id int, sort string) ([]models.Comment, error) {
 
-	rows, err := db.pool.Query(context.Background(), "SELECT id, post_id, parent_id, content, add_time FROM comments WHERE post_id = $1", id)
+	rows, err := db.pool.Query(context.Background(), "SELECT "+commentColumns+" FROM comments WHERE post_id = $1 AND status = 'approved'", id)
 	if err != nil {
 		return nil, err
 	}
@@ -69,8 +238,8 @@ func (db *DB) Comments(id int) ([]models.Comment, error) {
 
 	var comments []models.Comment
 	for rows.Next() {
-		var c models.Comment
-		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.AddTime); err != nil {
+		c, err := scanComment(rows)
+		if err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
@@ -80,42 +249,736 @@ func (db *DB) Comments(id int) ([]models.Comment, error) {
 		return nil, err
 	}
 
-	return buildCommentTree(comments), nil
+	return buildCommentTree(comments, sort), nil
 }
 
 
-// UpdateComment updates a comment in the database.
+// Sort modes of comments.
+const (
+	SortOldest  = "oldest"
+	SortNewest  = "newest"
+	SortReplies = "replies"
+	SortScore   = "score"
+)
+
+// Cursor points at the last comment of a page of top-level comments.
+// Key is the sort key of the comment: its add time, its number of replies
+// or its score, depending on the sort mode.
+//
+// The add time of a comment never changes, so the pages sorted by it neither skip
+// nor repeat comments. The number of replies and the score do change: the cursor
+// keeps the key the comment had when the page was read, so a comment whose key
+// changes in between may be skipped or shown again on the next pages.
+type Cursor struct {
+	Key int64
+	ID  int
+}
+
+// sortKeys maps a sort mode to the sort key, the comparison that selects
+// the comments after a cursor and the sort direction.
+// The keys are columns of comments, so that the pages are read from the indexes.
+var sortKeys = map[string]struct{ key, cmp, dir string }{
+	SortOldest:  {"add_time", ">", "ASC"},
+	SortNewest:  {"add_time", "<", "DESC"},
+	SortReplies: {"reply_count", "<", "DESC"},
+	SortScore:   {"score", "<", "DESC"},
+}
+
+// TopComments retrieves a page of the approved top-level comments of a post without their replies.
+//
+// TopComments takes a post ID, a sort mode, the cursor of the previous page
+// (nil for the first page) and the page size as arguments and will return
+// a slice of Comment objects with their numbers of replies and an error if any.
+func (db *DB) TopComments(postID int, sort string, after *Cursor, limit int) ([]models.Comment, error) {
+	s, ok := sortKeys[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort mode %q", sort)
+	}
+	var cursor Cursor
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT `+commentColumns+`, reply_count FROM comments
+		WHERE post_id = $1 AND parent_id = 0 AND status = 'approved'
+			AND ($2 OR (`+s.key+`, id) `+s.cmp+` ($3, $4))
+		ORDER BY `+s.key+` `+s.dir+`, id `+s.dir+`
+		LIMIT $5`, postID, after == nil, cursor.Key, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		var replies int
+		c, err := scanComment(rows, &replies)
+		if err != nil {
+			return nil, err
+		}
+		c.ReplyCount = replies
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Replies retrieves the subtree of approved replies of a comment.
+// Unless all is set, the comment itself must be approved and not deleted;
+// moderators see the replies of every comment.
+//
+// Replies takes a comment ID, a sort mode, see buildCommentTree, and the all flag as arguments
+// and will return a slice of its direct replies with their own replies attached,
+// ErrNotFound if there is no such comment and an error if any.
+func (db *DB) Replies(id int, sort string, all bool) ([]models.Comment, error) {
+
+	var visible bool
+	err := db.pool.QueryRow(context.Background(),
+		"SELECT $2 OR (status = $3 AND NOT deleted) FROM comments WHERE id = $1", id, all, StatusApproved).Scan(&visible)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && !visible {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(context.Background(), `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM comments WHERE parent_id = $1 AND status = 'approved'
+			UNION ALL
+			SELECT c.id FROM comments c JOIN subtree s ON c.parent_id = s.id WHERE c.status = 'approved'
+		)
+		SELECT `+commentColumns+` FROM comments
+		WHERE id IN (SELECT id FROM subtree)
+		ORDER BY add_time, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildTree(comments, id, sort), nil
+}
+
+
+// CountComments counts the comments of several posts at once.
+//
+// CountComments takes a slice of post IDs as argument and will return
+// the number of comments of each of them, including zeros, and an error if any.
+func (db *DB) CountComments(ids []int) (map[int]int, error) {
+
+	rows, err := db.pool.Query(context.Background(),
+		"SELECT post_id, COUNT(*) FROM comments WHERE post_id = ANY($1) AND status = 'approved' GROUP BY post_id", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+
+// Activity retrieves the comment activity of the posts commented since the given time.
+//
+// Activity takes a Unix time as argument and will return a slice of Activity
+// objects, the most recently active posts first, and an error if any.
+func (db *DB) Activity(since int64) ([]models.Activity, error) {
+
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT post_id, COUNT(*), COUNT(*) FILTER (WHERE add_time >= $1)
+		FROM comments
+		WHERE status = 'approved'
+		GROUP BY post_id
+		HAVING MAX(add_time) >= $1
+		ORDER BY 3 DESC, post_id`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activity []models.Activity
+	for rows.Next() {
+		var a models.Activity
+		if err := rows.Scan(&a.PostID, &a.Count, &a.Recent); err != nil {
+			return nil, err
+		}
+		activity = append(activity, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+
+// UpdateComment edits the content of a comment in the database.
+// The previous content is saved to the edit history, see Versions,
+// and the edit time and the number of edits of the comment are updated.
+// A comment can't be moved: its post and parent must stay the same, otherwise ErrMoved is returned.
+// An edit can only send an approved comment back to moderation, with the pending Status:
+// it never approves a pending comment, which waits for a moderator or was hidden by reports.
+// Rejected comments can't be edited, ErrRejected is returned for them.
 //
 // UpdateComment takes a Comment object as argument and will
-// return an error if any.
+// return an error if any. Deleted comments can't be updated, ErrNotFound is returned for them.
 func (db *DB) UpdateComment(c models.Comment) error {
-	_, err := db.pool.Exec(context.Background(),
-		"UPDATE comments SET post_id = $1, parent_id = $2, content = $3, add_time = $4 WHERE id = $5",
-		c.PostID, c.ParentID, c.Content, c.AddTime, c.ID)
-	return err
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var postID, parentID int
+	var content, status string
+	err = tx.QueryRow(ctx,
+		"SELECT post_id, COALESCE(parent_id, 0), content, status FROM comments WHERE id = $1 AND NOT deleted FOR UPDATE",
+		c.ID).Scan(&postID, &parentID, &content, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if c.PostID != postID || c.ParentID != parentID {
+		return ErrMoved
+	}
+	if status == StatusRejected {
+		return ErrRejected
+	}
+	if c.Status != StatusPending {
+		c.Status = status
+	}
+	if c.Content == content && c.Status == status {
+		return nil
+	}
+
+	editedAt := time.Now().Unix()
+	if c.Content != content {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO comment_versions (comment_id, content, edited_at) VALUES ($1, $2, $3)",
+			c.ID, content, editedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			"UPDATE comments SET content = $2, edited_at = $3, edit_count = edit_count + 1 WHERE id = $1",
+			c.ID, c.Content, editedAt)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, "UPDATE comments SET status = $2 WHERE id = $1", c.ID, c.Status)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
+// Versions retrieves the previous contents of an edited comment.
+// Unless all is set, only the history of approved comments that are not deleted is returned;
+// moderators see the history of every comment.
+//
+// Versions takes a comment ID and the all flag as arguments and will return a slice of
+// CommentVersion objects, the newest first, and an error if any.
+func (db *DB) Versions(id int, all bool) ([]models.CommentVersion, error) {
 
-// DeleteComment deletes a comment from the database.
+	rows, err := db.pool.Query(context.Background(),
+		`SELECT v.id, v.comment_id, v.content, v.edited_at FROM comment_versions v
+		JOIN comments c ON c.id = v.comment_id AND ($3 OR (c.status = $2 AND NOT c.deleted))
+		WHERE v.comment_id = $1 ORDER BY v.edited_at DESC, v.id DESC`, id, StatusApproved, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.CommentVersion
+	for rows.Next() {
+		var v models.CommentVersion
+		if err := rows.Scan(&v.ID, &v.CommentID, &v.Content, &v.EditedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+
+// PendingComments retrieves the moderation queue: the comments waiting
+// for a moderator, the oldest first.
+//
+// PendingComments takes the maximum number of comments as argument and will
+// return a slice of Comment objects and an error if any.
+func (db *DB) PendingComments(limit int) ([]models.Comment, error) {
+
+	rows, err := db.pool.Query(context.Background(),
+		"SELECT "+commentColumns+" FROM comments WHERE status = 'pending' ORDER BY add_time, id LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Moderate sets the moderation status of a comment.
+// The reports on an approved comment are dismissed, see Report.
+//
+// Moderate takes a comment ID, the new status and the reason of a rejection
+// as arguments and will return ErrNotFound if there is no such comment and an error if any.
+func (db *DB) Moderate(id int, status string, reason string) error {
+	tag, err := db.pool.Exec(context.Background(), `
+		WITH reports AS (DELETE FROM comment_reports WHERE comment_id = $1 AND $2 = 'approved')
+		UPDATE comments SET status = $2, reject_reason = $3 WHERE id = $1`, id, status, reason)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Report records the report of a reporter on a comment with one of the reasons.
+// When the number of reports of users reaches the threshold, an approved comment is hidden:
+// it becomes pending and waits for a moderator, see ReportedComments.
+// Anonymous reports, of reporters without the UserReporter prefix, are shown to moderators
+// but don't count toward the threshold: an anonymous client can't be told apart reliably.
+//
+// Report takes a comment ID, a reporter, a reason and the threshold as arguments and will
+// return whether the comment was hidden by the report, ErrNotFound if there is no such comment,
+// ErrAlreadyReported if the reporter has already reported it and an error if any.
+func (db *DB) Report(id int, reporter string, reason string, threshold int) (bool, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx,
+		"SELECT status FROM comments WHERE id = $1 AND NOT deleted FOR UPDATE", id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO comment_reports (comment_id, reporter, reason, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (comment_id, reporter) DO NOTHING`, id, reporter, reason, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, ErrAlreadyReported
+	}
+	var count int
+	err = tx.QueryRow(ctx,
+		"SELECT COUNT(*) FROM comment_reports WHERE comment_id = $1 AND reporter LIKE $2 || '%'", id, UserReporter).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	hidden := status == StatusApproved && count >= threshold
+	if hidden {
+		if _, err = tx.Exec(ctx, "UPDATE comments SET status = $2 WHERE id = $1", id, StatusPending); err != nil {
+			return false, err
+		}
+	}
+	return hidden, tx.Commit(ctx)
+}
+
+// ReportedComments retrieves the comments reported by readers, the most reported first,
+// with the number of reports for each reason. Deleted and rejected comments are left out.
+//
+// ReportedComments takes the maximum number of comments as argument and will
+// return a slice of ReportedComment objects and an error if any.
+func (db *DB) ReportedComments(limit int) ([]models.ReportedComment, error) {
+
+	rows, err := db.pool.Query(context.Background(), `
+		WITH reported AS (
+			SELECT r.comment_id, COUNT(*) AS total FROM comment_reports r
+			JOIN comments c ON c.id = r.comment_id AND NOT c.deleted AND c.status <> 'rejected'
+			GROUP BY r.comment_id
+			ORDER BY total DESC, r.comment_id
+			LIMIT $1
+		)
+		SELECT `+commentColumns+`, reported.total, comment_reports.reason, COUNT(*) FROM reported
+		JOIN comments ON comments.id = reported.comment_id
+		JOIN comment_reports ON comment_reports.comment_id = reported.comment_id
+		GROUP BY comments.id, reported.total, comment_reports.reason
+		ORDER BY reported.total DESC, comments.id, comment_reports.reason`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.ReportedComment
+	for rows.Next() {
+		var total, count int
+		var reason string
+		c, err := scanComment(rows, &total, &reason, &count)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(comments); n == 0 || comments[n-1].ID != c.ID {
+			comments = append(comments, models.ReportedComment{Comment: c, ReportCount: total, Reasons: map[string]int{}})
+		}
+		comments[len(comments)-1].Reasons[reason] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// checkParent checks that the parent of the comment exists, is approved
+// and belongs to the same post. Top-level comments have no parent.
+// The parent is locked until the end of the transaction, see AddComment.
+// The lock is exclusive, since the new reply updates the reply_count of the parent:
+// with shared locks, two concurrent replies would deadlock on the update.
+func checkParent(ctx context.Context, tx pgx.Tx, c models.Comment) error {
+	if c.ParentID == 0 {
+		return nil
+	}
+	var postID int
+	err := tx.QueryRow(ctx, "SELECT post_id FROM comments WHERE id = $1 AND status = 'approved' FOR NO KEY UPDATE", c.ParentID).Scan(&postID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrParentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if postID != c.PostID {
+		return ErrParentOnOtherPost
+	}
+	return nil
+}
+
+
+// DeleteComment marks a comment as deleted and blanks its content.
+// The comment stays in the tree with DeletedContent, so its replies are kept.
+// The edit history of the comment is kept for moderators, see Versions.
 //
 // DeleteComment takes a comment ID as argument and will
-// return an error if any.
+// return ErrNotFound if there is no such comment and an error if any.
 func (db *DB) DeleteComment(id int) error {
-	_, err := db.pool.Exec(context.Background(), "DELETE FROM comments WHERE id = $1", id)
-	return err
+	tag, err := db.pool.Exec(context.Background(),
+		"UPDATE comments SET deleted = TRUE, content = '' WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeComment removes a comment and all its replies from the database.
+// A reply added concurrently locks its parent, see AddComment, so it is committed
+// before its parent is removed, but the removal may not see it: the replies
+// of the removed comments are removed again until there are none left.
+//
+// PurgeComment takes a comment ID as argument and will return
+// the number of removed comments, ErrNotFound if there is no such comment
+// and an error if any.
+func (db *DB) PurgeComment(id int) (int, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := deleteComments(ctx, tx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM comments WHERE id = $1
+			UNION ALL
+			SELECT c.id FROM comments c JOIN subtree s ON c.parent_id = s.id
+		)
+		DELETE FROM comments WHERE id IN (SELECT id FROM subtree) RETURNING id`, id)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, ErrNotFound
+	}
+	count := len(ids)
+	for len(ids) > 0 {
+		ids, err = deleteComments(ctx, tx, "DELETE FROM comments WHERE parent_id = ANY($1) RETURNING id", ids)
+		if err != nil {
+			return 0, err
+		}
+		count += len(ids)
+	}
+	return count, tx.Commit(ctx)
+}
+
+// deleteComments runs a DELETE statement returning the IDs of the removed comments and returns them.
+func deleteComments(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CommentAuthor returns the ID of the author of a comment, 0 for anonymous comments.
+//
+// CommentAuthor takes a comment ID as argument and will return the ID of the author,
+// ErrNotFound if there is no such comment or it is deleted, and an error if any.
+func (db *DB) CommentAuthor(id int) (int, error) {
+	var authorID int
+	err := db.pool.QueryRow(context.Background(),
+		"SELECT COALESCE(author_id, 0) FROM comments WHERE id = $1 AND NOT deleted", id).Scan(&authorID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return authorID, nil
+}
+
+// AddUser registers a new user.
+//
+// AddUser takes a User object and the hash of the password as arguments and will
+// return the generated id of the user, ErrUserExists if the name is taken and an error if any.
+func (db *DB) AddUser(u models.User, passwordHash string) (int, error) {
+	var id int
+	err := db.pool.QueryRow(context.Background(), `
+		INSERT INTO users (name, password_hash, about, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO NOTHING RETURNING id`,
+		u.Name, passwordHash, u.About, u.CreatedAt).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserExists
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// User retrieves the profile of a user with the number of their approved comments.
+//
+// User takes a user ID as argument and will return a User object,
+// ErrUserNotFound if there is no such user and an error if any.
+func (db *DB) User(id int) (models.User, error) {
+	var u models.User
+	err := db.pool.QueryRow(context.Background(), `
+		SELECT id, name, about, created_at, role,
+			(SELECT COUNT(*) FROM comments WHERE author_id = users.id AND status = 'approved' AND NOT deleted)
+		FROM users WHERE id = $1`, id).Scan(&u.ID, &u.Name, &u.About, &u.CreatedAt, &u.Role, &u.CommentCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, nil
+}
+
+// PasswordHash retrieves the ID and the password hash of a user by name.
+//
+// PasswordHash takes a user name as argument and will return the ID of the user,
+// the hash of their password, ErrUserNotFound if there is no such user and an error if any.
+func (db *DB) PasswordHash(name string) (int, string, error) {
+	var id int
+	var hash string
+	err := db.pool.QueryRow(context.Background(),
+		"SELECT id, password_hash FROM users WHERE name = $1", name).Scan(&id, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", ErrUserNotFound
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return id, hash, nil
 }
-func buildCommentTree(comments []models.Comment) []models.Comment {
+
+// UpdateUser updates the profile of a user.
+//
+// UpdateUser takes a User object as argument and will
+// return ErrUserNotFound if there is no such user and an error if any.
+func (db *DB) UpdateUser(u models.User) error {
+	tag, err := db.pool.Exec(context.Background(), "UPDATE users SET about = $2 WHERE id = $1", u.ID, u.About)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Vote sets the vote of a voter on an approved comment: 1 for an upvote, -1 for a downvote,
+// 0 to take the vote back. A voter has one vote per comment, a new vote replaces the previous one.
+//
+// Vote takes a comment ID, a voter and a value as arguments and will return the new score
+// of the comment, ErrNotFound if there is no such approved comment and an error if any.
+func (db *DB) Vote(id int, voter string, value int) (int, error) {
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var score int
+	err = tx.QueryRow(ctx,
+		"SELECT score FROM comments WHERE id = $1 AND status = 'approved' AND NOT deleted FOR UPDATE", id).Scan(&score)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	var previous int
+	err = tx.QueryRow(ctx,
+		"SELECT value FROM comment_votes WHERE comment_id = $1 AND voter = $2", id, voter).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	if value == 0 {
+		_, err = tx.Exec(ctx, "DELETE FROM comment_votes WHERE comment_id = $1 AND voter = $2", id, voter)
+	} else {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO comment_votes (comment_id, voter, value) VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, voter) DO UPDATE SET value = EXCLUDED.value`, id, voter, value)
+	}
+	if err != nil {
+		return 0, err
+	}
+	score += value - previous
+	if _, err = tx.Exec(ctx, "UPDATE comments SET score = $2 WHERE id = $1", id, score); err != nil {
+		return 0, err
+	}
+	return score, tx.Commit(ctx)
+}
+
+// UserRole retrieves the current role of a user.
+//
+// UserRole takes a user ID as argument and will return the role of the user,
+// ErrUserNotFound if there is no such user and an error if any.
+func (db *DB) UserRole(id int) (string, error) {
+	var role string
+	err := db.pool.QueryRow(context.Background(), "SELECT role FROM users WHERE id = $1", id).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// SetRole changes the role of a user.
+//
+// SetRole takes a user ID and a role as arguments and will
+// return ErrUserNotFound if there is no such user and an error if any.
+func (db *DB) SetRole(id int, role string) error {
+	tag, err := db.pool.Exec(context.Background(), "UPDATE users SET role = $2 WHERE id = $1", id, role)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// buildCommentTree attaches the comments to their parents and returns the top-level ones.
+// Siblings are sorted by the sort mode: the oldest first, the newest first,
+// the ones with the most replies first or the ones with the highest score first.
+func buildCommentTree(comments []models.Comment, sort string) []models.Comment {
+	return buildTree(comments, 0, sort)
+}
+
+// buildTree attaches the comments to their parents
+// and returns the ones replying to rootID with their replies, see buildCommentTree.
+func buildTree(comments []models.Comment, rootID int, sort string) []models.Comment {
 	childrenMap := make(map[int][]models.Comment)
 	var roots []models.Comment
 
 	for _, comment := range comments {
-		if comment.ParentID == 0 {
+		if comment.ParentID == rootID {
 			roots = append(roots, comment)
 		} else {
 			childrenMap[comment.ParentID] = append(childrenMap[comment.ParentID], comment)
 		}
 	}
 
+	sortSiblings(roots, sort, childrenMap)
+	for _, children := range childrenMap {
+		sortSiblings(children, sort, childrenMap)
+	}
+
 	// iterative method
 	stack := make([]*models.Comment, len(roots))
 	for i := range roots {
@@ -150,3 +1013,29 @@ func buildCommentTree(comments []models.Comment) []models.Comment {
 
 	return roots
 }
+
+// sortSiblings sorts the replies of one comment by the sort mode, see buildCommentTree.
+// Ties and unknown sort modes keep the oldest first.
+func sortSiblings(siblings []models.Comment, mode string, children map[int][]models.Comment) {
+	key := func(c models.Comment) int64 {
+		switch mode {
+		case SortNewest:
+			return c.AddTime
+		case SortReplies:
+			return int64(len(children[c.ID]))
+		case SortScore:
+			return int64(c.Score)
+		}
+		return 0
+	}
+	sort.SliceStable(siblings, func(i, j int) bool {
+		a, b := siblings[i], siblings[j]
+		if ka, kb := key(a), key(b); ka != kb {
+			return ka > kb
+		}
+		if a.AddTime != b.AddTime {
+			return a.AddTime < b.AddTime
+		}
+		return a.ID < b.ID
+	})
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"Comments/pkg/models"
 	"context"
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -23,14 +24,10 @@ func TestMain(m *testing.M) {
 	}
 	defer testDB.pool.Close()
 
-	testDB.pool.Exec(context.Background(), "DROP TABLE IF EXISTS comments")
-	testDB.pool.Exec(context.Background(), `CREATE TABLE comments (
-		id SERIAL PRIMARY KEY, 
-		post_id BIGINT NOT NULL, 
-		parent_id BIGINT DEFAULT 0, 
-		content TEXT NOT NULL, 
-		add_time BIGINT NOT NULL
-		)`)
+	testDB.pool.Exec(context.Background(), "DROP TABLE IF EXISTS comments, schema_migrations")
+	if _, err := testDB.Migrate(); err != nil {
+		panic(err)
+	}
 
 	m.Run()
 }
@@ -46,6 +43,424 @@ func TestAddComment(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.NotZero(t, id, "ID should not be zero")
+
+	got, err := testDB.Comment(id)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.Content, got.Content)
+	assert.Equal(t, StatusApproved, got.Status)
+
+	_, err = testDB.Comment(1_000_000)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestActivity(t *testing.T) {
+	now := time.Now().Unix()
+	for _, addTime := range []int64{now - 7200, now - 60, now} {
+		_, err := testDB.AddComment(models.Comment{
+			PostID:  42,
+			Content: "test comment",
+			AddTime: addTime,
+			Status:  StatusApproved,
+		})
+		assert.NoError(t, err)
+	}
+
+	activity, err := testDB.Activity(now - 3600)
+	assert.NoError(t, err)
+	for _, a := range activity {
+		if a.PostID == 42 {
+			assert.Equal(t, models.Activity{PostID: 42, Count: 3, Recent: 2}, a)
+			return
+		}
+	}
+	t.Error("Should have the activity of the commented post")
+}
+
+func TestCountComments(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		_, err := testDB.AddComment(models.Comment{
+			PostID:  43,
+			Content: "test comment",
+			AddTime: time.Now().Unix(),
+			Status:  StatusApproved,
+		})
+		assert.NoError(t, err)
+	}
+
+	counts, err := testDB.CountComments([]int{43, 44})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{43: 2, 44: 0}, counts)
+}
+
+func TestTopComments(t *testing.T) {
+	now := time.Now().Unix()
+	var ids []int
+	for i := 0; i < 3; i++ {
+		id, err := testDB.AddComment(models.Comment{
+			PostID:  45,
+			Content: "top comment",
+			AddTime: now + int64(i),
+			Status:  StatusApproved,
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err := testDB.AddComment(models.Comment{
+		PostID:   45,
+		ParentID: ids[0],
+		Content:  "reply",
+		AddTime:  now,
+		Status:   StatusApproved,
+	})
+	assert.NoError(t, err)
+	rejectedID, err := testDB.AddComment(models.Comment{
+		PostID:   45,
+		ParentID: ids[0],
+		Content:  "rejected reply",
+		AddTime:  now,
+		Status:   StatusApproved,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, testDB.Moderate(rejectedID, StatusRejected, "spam"))
+
+	page, err := testDB.TopComments(45, SortNewest, nil, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, ids[2], page[0].ID)
+		assert.Equal(t, ids[1], page[1].ID)
+	}
+
+	page, err = testDB.TopComments(45, SortNewest, &Cursor{Key: page[1].AddTime, ID: page[1].ID}, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, ids[0], page[0].ID)
+		assert.Equal(t, 1, page[0].ReplyCount, "Only approved replies should be counted")
+	}
+
+	page, err = testDB.TopComments(45, SortReplies, nil, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, ids[0], page[0].ID, "The most replied comment should be first")
+	}
+}
+
+func TestReplies(t *testing.T) {
+	rootID, err := testDB.AddComment(models.Comment{PostID: 46, Content: "root", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	replyID, err := testDB.AddComment(models.Comment{PostID: 46, ParentID: rootID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	_, err = testDB.AddComment(models.Comment{PostID: 46, ParentID: replyID, Content: "nested reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	replies, err := testDB.Replies(rootID, SortOldest, false)
+	assert.NoError(t, err)
+	if assert.Len(t, replies, 1) {
+		assert.Equal(t, replyID, replies[0].ID)
+		assert.Len(t, replies[0].Replies, 1, "Nested replies should be attached")
+	}
+
+	_, err = testDB.Replies(1_000_000, SortOldest, true)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	pendingID, err := testDB.AddComment(models.Comment{PostID: 46, Content: "reported", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	_, err = testDB.AddComment(models.Comment{PostID: 46, ParentID: pendingID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	assert.NoError(t, testDB.Moderate(pendingID, StatusPending, ""))
+	_, err = testDB.Replies(pendingID, SortOldest, false)
+	assert.ErrorIs(t, err, ErrNotFound, "The replies of a pending comment should be hidden")
+	replies, err = testDB.Replies(pendingID, SortOldest, true)
+	assert.NoError(t, err)
+	assert.Len(t, replies, 1, "Moderators should see the replies of a pending comment")
+
+	assert.NoError(t, testDB.DeleteComment(rootID))
+	_, err = testDB.Replies(rootID, SortOldest, false)
+	assert.ErrorIs(t, err, ErrNotFound, "The replies of a deleted comment should be hidden")
+}
+
+func TestAddCommentInvalidParent(t *testing.T) {
+	parentID, err := testDB.AddComment(models.Comment{PostID: 47, Content: "parent", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	_, err = testDB.AddComment(models.Comment{PostID: 47, ParentID: 1_000_000, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.ErrorIs(t, err, ErrParentNotFound)
+
+	_, err = testDB.AddComment(models.Comment{PostID: 48, ParentID: parentID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.ErrorIs(t, err, ErrParentOnOtherPost)
+
+	_, err = testDB.AddComment(models.Comment{PostID: 47, ParentID: parentID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+}
+
+func TestDeleteCommentKeepsReplies(t *testing.T) {
+	parentID, err := testDB.AddComment(models.Comment{PostID: 49, Content: "parent", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	_, err = testDB.AddComment(models.Comment{PostID: 49, ParentID: parentID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testDB.DeleteComment(parentID))
+
+	comments, err := testDB.Comments(49, SortOldest)
+	assert.NoError(t, err)
+	if assert.Len(t, comments, 1) {
+		assert.True(t, comments[0].Deleted)
+		assert.Equal(t, DeletedContent, comments[0].Content)
+		assert.Len(t, comments[0].Replies, 1, "Replies of a deleted comment should be kept")
+	}
+
+	assert.ErrorIs(t, testDB.DeleteComment(1_000_000), ErrNotFound)
+}
+
+func TestPurgeComment(t *testing.T) {
+	parentID, err := testDB.AddComment(models.Comment{PostID: 50, Content: "parent", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+	_, err = testDB.AddComment(models.Comment{PostID: 50, ParentID: parentID, Content: "reply", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	n, err := testDB.PurgeComment(parentID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n, "The comment should be removed with its replies")
+
+	comments, err := testDB.Comments(50, SortOldest)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+}
+
+func TestAddCommentOnce(t *testing.T) {
+	key := fmt.Sprintf("key%d", time.Now().UnixNano())
+	c := models.Comment{PostID: 58, Content: "posted twice", AddTime: time.Now().Unix(), Status: StatusApproved}
+	id, added, err := testDB.AddCommentOnce(c, "user:1", key)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	// A retry gets the same comment without adding it again.
+	retryID, added, err := testDB.AddCommentOnce(c, "user:1", key)
+	assert.NoError(t, err)
+	assert.False(t, added)
+	assert.Equal(t, id, retryID)
+
+	// The key of another client is another key.
+	otherID, added, err := testDB.AddCommentOnce(c, "user:2", key)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.NotEqual(t, id, otherID)
+
+	c.Content = "another comment"
+	_, _, err = testDB.AddCommentOnce(c, "user:1", key)
+	assert.ErrorIs(t, err, ErrKeyReused)
+
+	comments, err := testDB.Comments(58, SortOldest)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 2)
+}
+
+func TestModeration(t *testing.T) {
+	id, err := testDB.AddComment(models.Comment{PostID: 51, Content: "borderline", AddTime: time.Now().Unix(), Status: StatusPending})
+	assert.NoError(t, err)
+
+	uncheckedID, err := testDB.AddComment(models.Comment{PostID: 51, Content: "unchecked", AddTime: time.Now().Unix()})
+	assert.NoError(t, err)
+	unchecked, err := testDB.Comment(uncheckedID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, unchecked.Status, "A comment without a status should wait for a moderator")
+
+	comments, err := testDB.Comments(51, SortOldest)
+	assert.NoError(t, err)
+	assert.Empty(t, comments, "Pending comments should not be shown")
+
+	pending, err := testDB.PendingComments(100)
+	assert.NoError(t, err)
+	var queued bool
+	for _, c := range pending {
+		queued = queued || c.ID == id
+	}
+	assert.True(t, queued, "Pending comments should be in the moderation queue")
+
+	assert.NoError(t, testDB.Moderate(id, StatusApproved, ""))
+	comments, err = testDB.Comments(51, SortOldest)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 1, "Approved comments should be shown")
+
+	assert.NoError(t, testDB.Moderate(id, StatusRejected, "spam"))
+	comments, err = testDB.Comments(51, SortOldest)
+	assert.NoError(t, err)
+	assert.Empty(t, comments, "Rejected comments should not be shown")
+}
+
+func TestEditHistory(t *testing.T) {
+	c := models.Comment{PostID: 52, Content: "first", AddTime: time.Now().Unix(), Status: StatusApproved}
+	id, err := testDB.AddComment(c)
+	assert.NoError(t, err)
+
+	c.ID = id
+	c.Content = "second"
+	assert.NoError(t, testDB.UpdateComment(c))
+	c.Content = "third"
+	assert.NoError(t, testDB.UpdateComment(c))
+
+	comments, err := testDB.Comments(52, SortOldest)
+	assert.NoError(t, err)
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "third", comments[0].Content)
+		assert.Equal(t, 2, comments[0].EditCount)
+		assert.NotZero(t, comments[0].EditedAt)
+	}
+
+	versions, err := testDB.Versions(id, false)
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, "second", versions[0].Content, "The newest version should be first")
+		assert.Equal(t, "first", versions[1].Content)
+	}
+
+	c.PostID = 53
+	assert.ErrorIs(t, testDB.UpdateComment(c), ErrMoved)
+	c.PostID = 52
+
+	// An edit can send a comment back to moderation, but never approve it.
+	c.Content, c.Status = "fourth", StatusPending
+	assert.NoError(t, testDB.UpdateComment(c))
+	c.Content, c.Status = "fifth", StatusApproved
+	assert.NoError(t, testDB.UpdateComment(c))
+	got, err := testDB.Comment(id)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, got.Status)
+
+	assert.NoError(t, testDB.Moderate(id, StatusRejected, "spam"))
+	assert.ErrorIs(t, testDB.UpdateComment(c), ErrRejected)
+
+	assert.NoError(t, testDB.DeleteComment(id))
+	versions, err = testDB.Versions(id, false)
+	assert.NoError(t, err)
+	assert.Empty(t, versions, "The history of a deleted comment should be hidden")
+	versions, err = testDB.Versions(id, true)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 4, "The history of a deleted comment should be kept for moderators")
+}
+
+func TestVote(t *testing.T) {
+	id, err := testDB.AddComment(models.Comment{PostID: 55, Content: "vote for me", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	score, err := testDB.Vote(id, "user:1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, score)
+	score, err = testDB.Vote(id, "user:1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, score, "A voter should have one vote")
+	score, err = testDB.Vote(id, "client:abc", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, score)
+	score, err = testDB.Vote(id, "user:1", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, -2, score, "A new vote should replace the previous one")
+	score, err = testDB.Vote(id, "client:abc", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, score)
+
+	comments, err := testDB.Comments(55, SortScore)
+	assert.NoError(t, err)
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, -1, comments[0].Score)
+	}
+
+	_, err = testDB.Vote(1_000_000, "user:1", 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestReport(t *testing.T) {
+	id, err := testDB.AddComment(models.Comment{PostID: 56, Content: "buy now", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	hidden, err := testDB.Report(id, "user:1", ReasonSpam, 2)
+	assert.NoError(t, err)
+	assert.False(t, hidden)
+	_, err = testDB.Report(id, "user:1", ReasonAbuse, 2)
+	assert.ErrorIs(t, err, ErrAlreadyReported)
+
+	hidden, err = testDB.Report(id, "user:2", ReasonAbuse, 2)
+	assert.NoError(t, err)
+	assert.True(t, hidden, "The comment should be hidden at the threshold")
+
+	comments, err := testDB.Comments(56, SortOldest)
+	assert.NoError(t, err)
+	assert.Empty(t, comments, "Hidden comments should not be shown")
+
+	reported, err := testDB.ReportedComments(100)
+	assert.NoError(t, err)
+	var found bool
+	for _, c := range reported {
+		if c.ID == id {
+			found = true
+			assert.Equal(t, 2, c.ReportCount)
+			assert.Equal(t, map[string]int{ReasonSpam: 1, ReasonAbuse: 1}, c.Reasons)
+		}
+	}
+	assert.True(t, found, "Reported comments should be in the moderator view")
+
+	assert.NoError(t, testDB.Moderate(id, StatusApproved, ""))
+	comments, err = testDB.Comments(56, SortOldest)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 1, "Approved comments should be shown again")
+	hidden, err = testDB.Report(id, "user:1", ReasonSpam, 2)
+	assert.NoError(t, err)
+	assert.False(t, hidden, "Reports should be dismissed on approval")
+}
+
+func TestReportAnonymous(t *testing.T) {
+	id, err := testDB.AddComment(models.Comment{PostID: 57, Content: "unpopular opinion", AddTime: time.Now().Unix(), Status: StatusApproved})
+	assert.NoError(t, err)
+
+	// One client behind one IP address rotating its User-Agent gets new fingerprints.
+	for _, fingerprint := range []string{"client:a", "client:b", "client:c"} {
+		hidden, err := testDB.Report(id, fingerprint, ReasonAbuse, 2)
+		assert.NoError(t, err)
+		assert.False(t, hidden, "Anonymous reports should not hide a comment")
+	}
+	comments, err := testDB.Comments(57, SortOldest)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 1)
+}
+
+func TestUsers(t *testing.T) {
+	name := fmt.Sprintf("user%d", time.Now().UnixNano())
+	id, err := testDB.AddUser(models.User{Name: name, CreatedAt: time.Now().Unix()}, "hash")
+	assert.NoError(t, err)
+
+	_, err = testDB.AddUser(models.User{Name: name, CreatedAt: time.Now().Unix()}, "hash")
+	assert.ErrorIs(t, err, ErrUserExists)
+
+	commentID, err := testDB.AddComment(models.Comment{PostID: 54, Content: "signed", AddTime: time.Now().Unix(), AuthorID: id, Status: StatusApproved})
+	assert.NoError(t, err)
+	authorID, err := testDB.CommentAuthor(commentID)
+	assert.NoError(t, err)
+	assert.Equal(t, id, authorID)
+
+	comments, err := testDB.Comments(54, SortOldest)
+	assert.NoError(t, err)
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, name, comments[0].AuthorName)
+	}
+
+	u, err := testDB.User(id)
+	assert.NoError(t, err)
+	assert.Equal(t, name, u.Name)
+	assert.Equal(t, 1, u.CommentCount)
+
+	gotID, hash, err := testDB.PasswordHash(name)
+	assert.NoError(t, err)
+	assert.Equal(t, id, gotID)
+	assert.Equal(t, "hash", hash)
+
+	assert.NoError(t, testDB.SetRole(id, RoleModerator))
+	role, err := testDB.UserRole(id)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleModerator, role)
+
+	_, err = testDB.User(1_000_000)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = testDB.UserRole(1_000_000)
+	assert.ErrorIs(t, err, ErrUserNotFound)
 }
 
 func TestGetComments(t *testing.T) {
@@ -58,7 +473,7 @@ func TestGetComments(t *testing.T) {
 	_, err := testDB.AddComment(comment)
 	assert.NoError(t, err)
 
-	comments, err := testDB.Comments(1)
+	comments, err := testDB.Comments(1, SortOldest)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, comments, "Should have at least one comment")
 	assert.Equal(t, comment.PostID, comments[0].PostID)
@@ -80,7 +495,7 @@ func TestUpdateComment(t *testing.T) {
 	err = testDB.UpdateComment(comment)
 	assert.NoError(t, err)
 
-	comments, err := testDB.Comments(2)
+	comments, err := testDB.Comments(2, SortOldest)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, comments, "Should have at least one comment")
 	assert.Equal(t, comment.Content, comments[0].Content)
@@ -99,7 +514,7 @@ func TestDeleteComment(t *testing.T) {
 	err = testDB.DeleteComment(id)
 	assert.NoError(t, err)
 
-	comments, err := testDB.Comments(3)
+	comments, err := testDB.Comments(3, SortOldest)
 	assert.NoError(t, err)
 	assert.Empty(t, comments, "Comments should be empty after deletion")
 }
@@ -107,6 +522,7 @@ func TestDeleteComment(t *testing.T) {
 func Test_buildCommentTree(t *testing.T) {
 	type args struct {
 		comments []models.Comment
+		sort     string
 	}
 	tests := []struct {
 		name string
@@ -139,6 +555,7 @@ func Test_buildCommentTree(t *testing.T) {
 						AddTime:  time.Now().Unix(),
 					},
 				},
+				sort: SortOldest,
 			},
 			want: []models.Comment{
 				{
@@ -168,10 +585,31 @@ func Test_buildCommentTree(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Test with siblings sorted by score",
+			args: args{
+				comments: []models.Comment{
+					{ID: 1, PostID: 1, AddTime: 100, Score: 1},
+					{ID: 2, PostID: 1, ParentID: 1, AddTime: 101, Score: -2},
+					{ID: 3, PostID: 1, ParentID: 1, AddTime: 102, Score: 5},
+					{ID: 4, PostID: 1, AddTime: 103, Score: 3},
+					{ID: 5, PostID: 1, AddTime: 104, Score: 3},
+				},
+				sort: SortScore,
+			},
+			want: []models.Comment{
+				{ID: 4, PostID: 1, AddTime: 103, Score: 3},
+				{ID: 5, PostID: 1, AddTime: 104, Score: 3},
+				{ID: 1, PostID: 1, AddTime: 100, Score: 1, Replies: []models.Comment{
+					{ID: 3, PostID: 1, ParentID: 1, AddTime: 102, Score: 5},
+					{ID: 2, PostID: 1, ParentID: 1, AddTime: 101, Score: -2},
+				}},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := buildCommentTree(tt.args.comments); !reflect.DeepEqual(got, tt.want) {
+			if got := buildCommentTree(tt.args.comments, tt.args.sort); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("accumulateComments() = \n%v\n, want \n%v\n", got, tt.want)
 			}
 		})
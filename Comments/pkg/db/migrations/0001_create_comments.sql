@@ -1,9 +1,7 @@
-DROP TABLE IF EXISTS comments;
-
-CREATE TABLE comments(
+CREATE TABLE IF NOT EXISTS comments (
   id SERIAL PRIMARY KEY,
   post_id BIGINT NOT NULL,
   parent_id BIGINT DEFAULT 0,
   content TEXT NOT NULL,
   add_time BIGINT NOT NULL
-);
\ No newline at end of file
+);
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS comments_post_idx ON comments (post_id, parent_id, add_time, id);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON comments (parent_id);
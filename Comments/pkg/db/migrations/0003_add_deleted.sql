@@ -0,0 +1,3 @@
+-- deleted marks the comments removed by their authors, their content is blanked
+-- but they stay in the tree so their replies are kept.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
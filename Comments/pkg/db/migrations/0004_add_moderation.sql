@@ -0,0 +1,6 @@
+-- status is the moderation status of a comment, only approved comments are shown.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'approved'
+  CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS reject_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS comments_pending_idx ON comments (add_time, id) WHERE status = 'pending';
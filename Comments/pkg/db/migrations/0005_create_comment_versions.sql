@@ -0,0 +1,13 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edit_count INTEGER NOT NULL DEFAULT 0;
+
+-- comment_versions holds the previous contents of edited comments.
+-- edited_at is the time a version was replaced by a newer one.
+CREATE TABLE IF NOT EXISTS comment_versions (
+  id SERIAL PRIMARY KEY,
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  content TEXT NOT NULL,
+  edited_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS comment_versions_comment_idx ON comment_versions (comment_id, edited_at);
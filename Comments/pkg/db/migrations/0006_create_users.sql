@@ -0,0 +1,13 @@
+-- users are the registered authors of comments, passwords are stored as bcrypt hashes.
+CREATE TABLE IF NOT EXISTS users (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  password_hash TEXT NOT NULL,
+  about TEXT NOT NULL DEFAULT '',
+  created_at BIGINT NOT NULL
+);
+
+-- author_id is NULL for anonymous comments.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS author_id INTEGER REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS comments_author_idx ON comments (author_id);
@@ -0,0 +1,3 @@
+-- role is the role of a user: readers write comments, moderators moderate them, admins also manage users.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'reader'
+  CHECK (role IN ('reader', 'moderator', 'admin'));
@@ -0,0 +1,13 @@
+-- comment_votes holds one vote per voter and comment: 1 for an upvote, -1 for a downvote.
+-- voter is "user:<id>" for authenticated users and "client:<fingerprint>" for anonymous ones.
+CREATE TABLE IF NOT EXISTS comment_votes (
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  voter TEXT NOT NULL,
+  value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+  PRIMARY KEY (comment_id, voter)
+);
+
+-- score is the sum of the votes of a comment.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS score INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS comments_post_score_idx ON comments (post_id, score DESC, id DESC) WHERE parent_id = 0 AND status = 'approved';
@@ -0,0 +1,9 @@
+-- comment_reports holds the reports of readers on abusive comments, one per reporter and comment.
+-- reporter is "user:<id>" for authenticated users and "client:<fingerprint>" for anonymous ones.
+CREATE TABLE IF NOT EXISTS comment_reports (
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  reporter TEXT NOT NULL,
+  reason TEXT NOT NULL CHECK (reason IN ('spam', 'abuse', 'offtopic', 'other')),
+  created_at BIGINT NOT NULL,
+  PRIMARY KEY (comment_id, reporter)
+);
@@ -0,0 +1,10 @@
+-- idempotency_scope and idempotency_key identify the request that added a comment:
+-- a retry of the request with the same key gets the comment instead of adding it again.
+-- The scope is the client of the request, "user:<id>" or "client:<fingerprint>", so the keys
+-- of different clients never clash. idempotency_hash is the hash of the comment sent with the key.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS idempotency_scope TEXT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS idempotency_key TEXT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS idempotency_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS comments_idempotency_idx ON comments (idempotency_scope, idempotency_key)
+  WHERE idempotency_key IS NOT NULL;
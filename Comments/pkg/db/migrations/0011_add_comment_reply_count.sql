@@ -0,0 +1,37 @@
+-- reply_count is the number of approved replies of a comment, the sort key of the replies sort mode.
+-- It is kept by the comments_reply_count trigger on every change of the replies,
+-- so that the pages sorted by it are read from an index instead of counting the replies of every comment.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE comments SET reply_count = (
+  SELECT COUNT(*) FROM comments r WHERE r.parent_id = comments.id AND r.status = 'approved'
+);
+
+CREATE OR REPLACE FUNCTION comments_count_replies() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    IF OLD.status IS NOT DISTINCT FROM NEW.status AND OLD.parent_id IS NOT DISTINCT FROM NEW.parent_id THEN
+      RETURN NULL;
+    END IF;
+  END IF;
+  IF TG_OP IN ('UPDATE', 'DELETE') THEN
+    IF OLD.status = 'approved' AND COALESCE(OLD.parent_id, 0) <> 0 THEN
+      UPDATE comments SET reply_count = reply_count - 1 WHERE id = OLD.parent_id;
+    END IF;
+  END IF;
+  IF TG_OP IN ('INSERT', 'UPDATE') THEN
+    IF NEW.status = 'approved' AND COALESCE(NEW.parent_id, 0) <> 0 THEN
+      UPDATE comments SET reply_count = reply_count + 1 WHERE id = NEW.parent_id;
+    END IF;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS comments_reply_count ON comments;
+CREATE TRIGGER comments_reply_count
+  AFTER INSERT OR DELETE OR UPDATE OF status, parent_id ON comments
+  FOR EACH ROW EXECUTE FUNCTION comments_count_replies();
+
+CREATE INDEX IF NOT EXISTS comments_post_replies_idx ON comments (post_id, reply_count DESC, id DESC)
+  WHERE parent_id = 0 AND status = 'approved';
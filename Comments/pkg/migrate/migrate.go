@@ -0,0 +1,137 @@
+// Package migrate applies the versioned SQL migrations of a service to its database.
+//
+// The package is copied in Gonews/pkg/migrate and Comments/pkg/migrate, since the services
+// are separate Go modules without a shared one. A change to one copy must be made to the other;
+// the copies differ only in lockKey, which keeps the locks of the two services apart.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// lockKey is the key of the advisory lock held while applying a migration,
+// so several instances started at once don't apply the same migration twice.
+const lockKey = 7_301_002
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Load reads the migrations from the root of fsys.
+// Migration files are named like 0001_create_posts.sql, where the leading number
+// is the version. The migrations are returned sorted by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, f := range files {
+		name := strings.TrimSuffix(path.Base(f), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q: must start with a positive version number", f)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version %d", other, f, version)
+		}
+		seen[version] = f
+
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, fmt.Errorf("can't read migration %q: %w", f, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies the migrations from fsys that are not applied yet in order of their versions
+// and returns the number of applied migrations.
+// The applied versions are recorded in the schema_migrations table.
+// Each migration runs in its own transaction, so a failed migration leaves no trace.
+func Up(pool *pgxpool.Pool, fsys fs.FS) (int, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at BIGINT NOT NULL
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("can't create schema_migrations table: %w", err)
+	}
+
+	var applied int
+	for _, m := range migrations {
+		ok, err := apply(ctx, pool, m)
+		if err != nil {
+			return applied, fmt.Errorf("can't apply migration %s: %w", m.Name, err)
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// apply runs the migration unless it is already recorded as applied.
+// It reports whether the migration was run.
+func apply(ctx context.Context, pool *pgxpool.Pool, m Migration) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return false, err
+	}
+
+	var done bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)
+	`, m.Version).Scan(&done)
+	if err != nil {
+		return false, err
+	}
+	if done {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, m.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)
+	`, m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
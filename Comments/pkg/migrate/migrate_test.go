@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.sql":       {Data: []byte("CREATE INDEX comments_post_id_idx ON comments (post_id);")},
+		"0001_create_comments.sql": {Data: []byte("CREATE TABLE comments (id SERIAL PRIMARY KEY);")},
+		"0010_add_status.sql":      {Data: []byte("ALTER TABLE comments ADD COLUMN status TEXT;")},
+		"README.md":                {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("got %d migrations, want 3", len(migrations))
+	}
+	for i, want := range []int{1, 2, 10} {
+		if migrations[i].Version != want {
+			t.Errorf("migrations[%d].Version = %d, want %d", i, migrations[i].Version, want)
+		}
+	}
+	if migrations[0].Name != "0001_create_comments" {
+		t.Errorf("migrations[0].Name = %q, want %q", migrations[0].Name, "0001_create_comments")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"no version": {
+			"create_comments.sql": {Data: []byte("")},
+		},
+		"duplicate version": {
+			"0001_create_comments.sql": {Data: []byte("")},
+			"1_add_index.sql":          {Data: []byte("")},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(fsys); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -1,10 +1,69 @@
 package models
 
 type Comment struct {
-	ID       int        `json:"ID"`
-	PostID   int        `json:"PostID"`
-	ParentID int        `json:"ParentID"`
-	Content  string     `json:"Content"`
-	AddTime  int64      `json:"AddTime"`
-	Replies  []Comment `json:"Replies"`
+	ID       int    `json:"ID"`
+	PostID   int    `json:"PostID"`
+	ParentID int    `json:"ParentID"`
+	Content  string `json:"Content"`
+	AddTime  int64  `json:"AddTime"`
+	Deleted  bool   `json:"Deleted"`
+	// Status is the moderation status: pending, approved or rejected.
+	Status       string    `json:"Status"`
+	RejectReason string    `json:"RejectReason"`
+	Replies      []Comment `json:"Replies"`
+	// ReplyCount is the number of direct replies,
+	// set when a comment is listed without its replies.
+	ReplyCount int `json:"ReplyCount"`
+	// EditedAt is the time of the last edit, 0 if the comment was not edited.
+	EditedAt  int64 `json:"EditedAt"`
+	EditCount int   `json:"EditCount"`
+	// AuthorID is the ID of the user who wrote the comment, 0 for anonymous comments.
+	AuthorID   int    `json:"AuthorID"`
+	AuthorName string `json:"AuthorName"`
+	// Score is the number of upvotes minus the number of downvotes.
+	Score int `json:"Score"`
+}
+
+// User is the public profile of a registered comment author.
+// CommentCount is the number of their approved comments.
+type User struct {
+	ID        int    `json:"ID"`
+	Name      string `json:"Name"`
+	About     string `json:"About"`
+	CreatedAt int64  `json:"CreatedAt"`
+	// Role is reader, moderator or admin.
+	Role         string `json:"Role"`
+	CommentCount int    `json:"CommentCount"`
+}
+
+// ReportedComment is a comment reported by readers, see the moderator view of the reports.
+// Reasons holds the number of reports for each reason.
+type ReportedComment struct {
+	Comment
+	ReportCount int            `json:"ReportCount"`
+	Reasons     map[string]int `json:"Reasons"`
+}
+
+// CommentVersion is a previous content of an edited comment.
+// EditedAt is the time the version was replaced by a newer one.
+type CommentVersion struct {
+	ID        int    `json:"ID"`
+	CommentID int    `json:"CommentID"`
+	Content   string `json:"Content"`
+	EditedAt  int64  `json:"EditedAt"`
+}
+
+// CommentPage is a page of the top-level comments of a post.
+// NextCursor points past the last comment of the page, it is empty on the last page.
+type CommentPage struct {
+	Comments   []Comment `json:"Comments"`
+	NextCursor string    `json:"NextCursor"`
+}
+
+// Activity is the comment activity of a post:
+// Count is the number of all its comments, Recent is the number of comments added since a given time.
+type Activity struct {
+	PostID int `json:"PostID"`
+	Count  int `json:"Count"`
+	Recent int `json:"Recent"`
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/suxrobshukurov/gonews/pkg/api"
+	"github.com/suxrobshukurov/gonews/pkg/pipeline"
 	"github.com/suxrobshukurov/gonews/pkg/rss"
 	"github.com/suxrobshukurov/gonews/pkg/storage"
 	"github.com/suxrobshukurov/gonews/pkg/storage/memdb"
@@ -18,8 +20,20 @@ import (
 )
 
 type gonewsConfig struct {
-	URLS   []string `json:"rss"`
-	Period int      `json:"request_period"`
+	URLS      []string        `json:"rss"`
+	Period    int             `json:"request_period"`
+	Retention retentionConfig `json:"retention"`
+	Pipeline  pipeline.Config `json:"pipeline"`
+}
+
+// retentionConfig configures the job removing old posts.
+// Zero MaxAgeDays and MaxPerSource disable the job.
+// Period is the interval between runs in minutes.
+type retentionConfig struct {
+	MaxAgeDays   int  `json:"max_age_days"`
+	MaxPerSource int  `json:"max_per_source"`
+	Archive      bool `json:"archive"`
+	Period       int  `json:"period"`
 }
 
 type server struct {
@@ -28,13 +42,28 @@ type server struct {
 }
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply database migrations and exit")
+	flag.Parse()
+
 	// init requirements
 	var srv server
-	var err error
-	srv.db, err = postgres.New()
+	pg, err := postgres.New()
 	if err != nil {
+		if *migrateOnly {
+			log.Fatalf("failed to create a postgress database: %v", err)
+		}
 		srv.db, _ = memdb.New()
 		log.Printf("failed to create a postgress database: %v\n memdb was launched instead", err)
+	} else {
+		n, err := pg.Migrate()
+		if err != nil {
+			log.Fatal("failed to migrate database: ", err)
+		}
+		log.Printf("applied %d database migrations", n)
+		if *migrateOnly {
+			return
+		}
+		srv.db = pg
 	}
 	srv.api = api.New(srv.db)
 	port := ":8081"
@@ -58,6 +87,12 @@ func main() {
 		log.Fatal("failed to unmarshal config: ", err)
 	}
 
+	// build the ingestion pipeline
+	p, err := pipeline.Build(config.Pipeline, srv.db)
+	if err != nil {
+		log.Fatal("failed to build ingestion pipeline: ", err)
+	}
+
 	// parse urls in goroutine
 	chPosts := make(chan []storage.Post)
 	chErros := make(chan error)
@@ -65,16 +100,18 @@ func main() {
 		go parseUrl(url, chPosts, chErros, config.Period)
 	}
 
-	// read channels and send to db
+	// read channels and send posts through the ingestion pipeline
 	go func() {
 		for posts := range chPosts {
-			err = srv.db.AddPosts(posts)
-			if err != nil {
+			if _, err := p.Run(posts); err != nil {
 				log.Printf("failed to add posts: %v", err)
 			}
 		}
 	}()
 
+	// remove old posts
+	go retain(srv.db, config.Retention)
+
 	// read errors and log them
 	go func() {
 		for err := range chErros {
@@ -109,3 +146,31 @@ func parseUrl(url string, chPosts chan<- []storage.Post, chErrors chan<- error,
 		time.Sleep(time.Minute * time.Duration(period))
 	}
 }
+
+// retain periodically applies the retention policy to the database
+// and logs how many posts were removed.
+func retain(db storage.Interface, config retentionConfig) {
+	if config.MaxAgeDays <= 0 && config.MaxPerSource <= 0 {
+		return
+	}
+	period := config.Period
+	if period <= 0 {
+		period = 60
+	}
+	for {
+		r := storage.Retention{
+			KeepPerSource: config.MaxPerSource,
+			Archive:       config.Archive,
+		}
+		if config.MaxAgeDays > 0 {
+			r.Before = time.Now().AddDate(0, 0, -config.MaxAgeDays).Unix()
+		}
+		n, err := db.ApplyRetention(r)
+		if err != nil {
+			log.Printf("failed to apply retention policy: %v", err)
+		} else {
+			log.Printf("retention policy removed %d posts", n)
+		}
+		time.Sleep(time.Minute * time.Duration(period))
+	}
+}
@@ -1,23 +1,55 @@
 package api
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/suxrobshukurov/gonews/pkg/diff"
 	"github.com/suxrobshukurov/gonews/pkg/paginate"
 	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/tags"
 )
 
 const (
 	reqIDStr string = "requset_id"
+	// maxBatchSize is the maximum number of posts that can be requested at once.
+	maxBatchSize int = 100
+	// relatedLimit is the default number of related posts, maxRelatedLimit is the largest allowed.
+	relatedLimit    int = 5
+	maxRelatedLimit int = 20
+	// relatedWindow is the time in seconds around the publication of a post
+	// within which related posts are looked for.
+	relatedWindow int64 = 30 * 24 * 60 * 60
+	// cacheControl lets clients and proxies reuse a response for a minute
+	// and revalidate it with the ETag or the Last-Modified time afterwards.
+	cacheControl string = "public, max-age=60, must-revalidate"
 )
 
+// Revision is a previous version of a post along with the changes
+// that turned it into the next, newer version.
+type Revision struct {
+	storage.Revision
+	TitleDiff   []diff.Op
+	ContentDiff []diff.Op
+}
+
+// Batch is the response of the batch post lookup.
+// Posts are listed in the requested order, Missing holds the IDs that were not found.
+type Batch struct {
+	Posts   []storage.Post
+	Missing []int
+}
+
 // API struct
 type API struct {
 	r  *mux.Router
@@ -43,10 +75,15 @@ func (api *API) Router() *mux.Router {
 func (api *API) endpoints() {
 	api.r.Use(headersMiddleware)
 	api.r.Use(logMiddleware)
+	api.r.Use(api.modifiedMiddleware)
 
 	api.r.HandleFunc("/news", api.postsHandler).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/news/id", api.postById).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/id/revisions", api.revisions).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/id/related", api.related).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/news/batch", api.postsByIDs).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/news/filter", api.filternews).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/tags", api.tagsHandler).Methods(http.MethodGet, http.MethodOptions)
 	// web app
 	// api.r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("./webapp"))))
 }
@@ -66,6 +103,46 @@ func headersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// modifiedKey is the request context key of the time of the last change of the posts.
+type modifiedKey struct{}
+
+// modifiedMiddleware reads the time of the last change of the posts, see storage.Interface,
+// before a GET request is handled, so that a change made while the response is built
+// gets a later time than the one sent with the response, see writeCached.
+// If the request carries an If-Modified-Since header and the posts haven't changed since,
+// it responds with 304 Not Modified right away, unless the request also carries
+// an If-None-Match header, which takes precedence.
+// If the time can't be read, the request is handled without it.
+func (api *API) modifiedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		modified, err := api.db.Modified()
+		if err != nil {
+			log.Printf("RequestID: %s can't get the modification time: %s", r.URL.Query().Get(reqIDStr), err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "" {
+			since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+			if err == nil && modified <= since.Unix() {
+				w.Header().Set("Last-Modified", httpTime(modified))
+				w.Header().Set("Cache-Control", cacheControl)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), modifiedKey{}, modified)))
+	})
+}
+
+// httpTime formats a Unix time for the HTTP headers.
+func httpTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(http.TimeFormat)
+}
+
 // logMiddleware logs the request ID, IP, method, URL and duration of the request.
 // It is meant to be used as a middleware for the API.
 // It logs the request duration using the time.Since function.
@@ -103,7 +180,7 @@ func (api *API) postById(w http.ResponseWriter, r *http.Request) {
 
 	post, err := api.db.PostByID(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, storage.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte(`{"error": "Post not found"}`))
 			return
@@ -112,20 +189,180 @@ func (api *API) postById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(post)
+	err = writeCached(w, r, post)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Can't encode post. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 }
 
+// revisions handles the HTTP GET request to retrieve the edit history of a post.
+// It extracts the "id" query parameter from the request URL and fetches the post
+// and its previous versions from the database.
+// Each revision, the newest first, is returned along with the word-level diff
+// of its title and content against the version that replaced it.
+// If the ID is invalid, it returns an HTTP 400 error response.
+func (api *API) revisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Invalid post ID. Error: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	post, err := api.db.PostByID(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Post not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Can't get post by ID. Error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	revisions, err := api.db.Revisions(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Can't get revisions. Error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]Revision, 0, len(revisions))
+	newerTitle, newerContent := post.Title, post.Content
+	for _, rev := range revisions {
+		res = append(res, Revision{
+			Revision:    rev,
+			TitleDiff:   diff.Words(rev.Title, newerTitle),
+			ContentDiff: diff.Words(rev.Content, newerContent),
+		})
+		newerTitle, newerContent = rev.Title, rev.Content
+	}
+
+	if err := writeCached(w, r, res); err != nil {
+		http.Error(w, fmt.Sprintf("Can't encode revisions. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// related handles the HTTP GET request to retrieve the posts similar to a given one.
+// It extracts the "id" query parameter from the request URL and the optional "limit"
+// query parameter, which defaults to relatedLimit and can't exceed maxRelatedLimit.
+// Posts are similar if they share tags or keywords and were published within
+// relatedWindow of each other, the ones sharing more of them are listed first.
+// If the ID or the limit is invalid, it returns an HTTP 400 error response.
+// If the post is not found, it returns an HTTP 404 error response.
+func (api *API) related(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Invalid post ID. Error: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	limit := relatedLimit
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit < 1 || limit > maxRelatedLimit {
+			http.Error(w, fmt.Sprintf(`{"error": "Invalid limit, it must be between 1 and %d"}`, maxRelatedLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	posts, err := api.db.Related(id, relatedWindow, limit)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Post not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Can't get related posts. Error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if posts == nil {
+		posts = []storage.Post{}
+	}
+
+	if err := writeCached(w, r, posts); err != nil {
+		http.Error(w, fmt.Sprintf("Can't encode posts. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// postsByIDs handles the HTTP GET request to retrieve several posts by their IDs at once.
+// The IDs are passed as a comma-separated "ids" query parameter, e.g. ids=1,2,3.
+// Duplicate IDs are looked up once. If the IDs are invalid or there are more than
+// maxBatchSize of them, it returns an HTTP 400 error response.
+// The found posts are returned in the requested order along with the IDs that were not found.
+func (api *API) postsByIDs(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Invalid post IDs. Error: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxBatchSize {
+		http.Error(w, fmt.Sprintf(`{"error": "Too many post IDs, at most %d are allowed"}`, maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	posts, err := api.db.PostsByIDs(ids)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Can't get posts by IDs. Error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	res := Batch{Posts: []storage.Post{}, Missing: []int{}}
+	found := make(map[int]bool, len(posts))
+	for _, p := range posts {
+		res.Posts = append(res.Posts, p)
+		found[p.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			res.Missing = append(res.Missing, id)
+		}
+	}
+
+	if err := writeCached(w, r, res); err != nil {
+		http.Error(w, fmt.Sprintf("Can't encode posts. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// parseIDs parses a comma-separated list of post IDs, dropping duplicates
+// and keeping the order of the first occurrence.
+func parseIDs(str string) ([]int, error) {
+	if str == "" {
+		return nil, errors.New("empty list of IDs")
+	}
+	var ids []int
+	seen := make(map[int]bool)
+	for _, s := range strings.Split(str, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
 
 // postsHandler handles the HTTP GET request to retrieve a paginated list of posts.
+// If the "tag" query parameter is set, only posts marked by that tag are listed.
+// If the "lang" query parameter is set, only posts in that language are listed.
+// The two parameters can't be combined, such a request gets an HTTP 400 error response.
 // It first fetches the total count of posts from the database.
 // If an error occurs while fetching the count, it returns an HTTP 500 error response.
 // If successful, it invokes the handlePagination method to manage pagination
 // and fetches posts using the Posts method, encoding the result in JSON format.
 func (api *API) postsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("tag") != "" && r.URL.Query().Get("lang") != "" {
+		http.Error(w, "The tag and lang parameters can't be combined", http.StatusBadRequest)
+		return
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		api.postsByTag(w, r, tags.Normalize(tag))
+		return
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		api.filternews(w, r)
+		return
+	}
+
 	count, err := api.db.Count()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Can't get count. Error: %s", err.Error()), http.StatusInternalServerError)
@@ -137,23 +374,67 @@ func (api *API) postsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// postsByTag handles the HTTP GET request to retrieve a paginated list of posts
+// marked by the given tag.
+//
+// It first fetches the total count of posts marked by the tag from the database.
+// If an error occurs while fetching the count, it returns an HTTP 500 error response.
+// If successful, it invokes the handlePagination method to manage pagination
+// and fetches posts using the PostsByTag method, encoding the result in JSON format.
+func (api *API) postsByTag(w http.ResponseWriter, r *http.Request, tag string) {
+	count, err := api.db.CountOfTag(tag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Can't get count. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	api.handlePagination(w, r, count, func(start, limit int) ([]storage.Post, error) {
+		return api.db.PostsByTag(tag, start, limit)
+	})
+}
+
+// tagsHandler handles the HTTP GET request to retrieve all tags
+// along with the number of posts marked by each of them.
+// If an error occurs while fetching the tags, it returns an HTTP 500 error response.
+func (api *API) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := api.db.Tags()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Can't get tags. Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		res = []storage.Tag{}
+	}
+
+	if err := writeCached(w, r, res); err != nil {
+		http.Error(w, fmt.Sprintf("Can't encode tags. Error: %s", err.Error()), http.StatusInternalServerError)
+	}
+}
+
 // filternews handles the HTTP GET request to retrieve a paginated list of posts
 // that match a search pattern.
 //
+// The search pattern is taken from the "s" query parameter and matched against titles
+// and, as a full-text query, against contents. The optional "keyword" and "lang"
+// query parameters limit the list to the posts with that keyword and in that language.
 // It first fetches the total count of posts matching the search pattern from the database.
 // If an error occurs while fetching the count, it returns an HTTP 500 error response.
 // If successful, it invokes the handlePagination method to manage pagination
 // and fetches posts using the Filter method, encoding the result in JSON format.
 func (api *API) filternews(w http.ResponseWriter, r *http.Request) {
-	strSearch := r.URL.Query().Get("s")
-	count, err := api.db.CountOfFilter(strSearch)
+	q := storage.Query{
+		Search:  r.URL.Query().Get("s"),
+		Keyword: strings.ToLower(strings.TrimSpace(r.URL.Query().Get("keyword"))),
+		Lang:    strings.ToLower(strings.TrimSpace(r.URL.Query().Get("lang"))),
+	}
+	count, err := api.db.CountOfFilter(q)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Can't get count. Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 
 	api.handlePagination(w, r, count, func(start, limit int) ([]storage.Post, error) {
-		return api.db.Filter(strSearch, start, limit)
+		return api.db.Filter(q, start, limit)
 	})
 
 }
@@ -178,7 +459,7 @@ func (api *API) handlePagination(w http.ResponseWriter, r *http.Request, totalCo
 			Posts:      []storage.Post{},
 			Pagination: paginate.Pagination{CurrentPage: 1, TotalPages: 1, NumberOfPosts: 0},
 		}
-		if err := json.NewEncoder(w).Encode(res); err != nil {
+		if err := writeCached(w, r, res); err != nil {
 			http.Error(w, fmt.Sprintf("Can't encode response. Error: %s", err.Error()), http.StatusInternalServerError)
 		}
 		return
@@ -209,8 +490,52 @@ func (api *API) handlePagination(w http.ResponseWriter, r *http.Request, totalCo
 		Pagination: p,
 	}
 
-	if err := json.NewEncoder(w).Encode(res); err != nil {
+	if err := writeCached(w, r, res); err != nil {
 		http.Error(w, fmt.Sprintf("Can't encode posts. Error: %s", err.Error()), http.StatusInternalServerError)
 	}
 
 }
+
+// writeCached encodes v into JSON and writes it to the response along with
+// the ETag, Last-Modified and Cache-Control headers.
+// The ETag is a hash of the encoded response, so it changes whenever any of the
+// returned posts does, including their tags and keywords and the set of posts on a page.
+// The Last-Modified time is the time of the last change of any post, read by
+// modifiedMiddleware: a page also changes when posts move between pages, and the
+// publication time of a post doesn't change when the source edits the post.
+// If the request carries a matching If-None-Match header, it responds with
+// 304 Not Modified and no body.
+func writeCached(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	sum := sha1.Sum(b)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if modified, ok := r.Context().Value(modifiedKey{}).(int64); ok {
+		w.Header().Set("Last-Modified", httpTime(modified))
+	}
+
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// notModified reports whether the client's cached copy identified by the
+// If-None-Match request header is still fresh.
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -9,8 +9,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/suxrobshukurov/gonews/pkg/diff"
 	"github.com/suxrobshukurov/gonews/pkg/paginate"
 	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/storage/memdb"
 	"github.com/suxrobshukurov/gonews/pkg/storage/postgres"
 )
 
@@ -127,3 +129,260 @@ func TestFilterPostsInvalidPage(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestPostsByIDs(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Test Post 1", Link: "http://example.com/1"},
+		{Title: "Test Post 2", Link: "http://example.com/2"},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/batch?ids=2,5,1,2", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response Batch
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Len(t, response.Posts, 2, "Both existing posts should be returned")
+	assert.Equal(t, 2, response.Posts[0].ID, "Posts should keep the requested order")
+	assert.Equal(t, 1, response.Posts[1].ID, "Posts should keep the requested order")
+	assert.Equal(t, []int{5}, response.Missing, "Unknown IDs should be reported")
+}
+
+func TestPostsByInvalidIDs(t *testing.T) {
+	db, _ := memdb.New()
+	api := New(db)
+
+	for _, ids := range []string{"", "1,a", "1,,2"} {
+		req := httptest.NewRequest(http.MethodGet, "/news/batch?ids="+ids, nil)
+		w := httptest.NewRecorder()
+
+		api.Router().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, "ids=%q should be rejected", ids)
+	}
+}
+
+func TestPostByIDNotModified(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Test Post 1", PubTime: 1700000000, Link: "http://example.com/1"},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	w := httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag, "ETag should be set")
+	assert.NotEmpty(t, w.Header().Get("Cache-Control"), "Cache-Control should be set")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, lastModified, "Last-Modified should be set")
+
+	req = httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes(), "304 response should have no body")
+
+	req = httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes(), "304 response should have no body")
+
+	// The source edits the post without changing its publication time.
+	db.AddPosts([]storage.Post{
+		{Title: "Test Post 1", Content: "Corrected", PubTime: 1700000000, Link: "http://example.com/1"},
+	})
+	req = httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	req.Header.Set("If-None-Match", etag)
+	req.Header.Set("If-Modified-Since", "Tue, 14 Nov 2023 22:13:20 GMT")
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "An edited post should not be answered with 304")
+
+	// The edit is made within the same second, but it still moves the time forward.
+	req = httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "An edited post should not be answered with 304")
+	assert.NotEqual(t, lastModified, w.Header().Get("Last-Modified"))
+
+	req = httptest.NewRequest(http.MethodGet, "/news/id?id=1", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRevisions(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Go 1.22 is released", Content: "Release notes", Link: "http://example.com/1"},
+	})
+	db.AddPosts([]storage.Post{
+		{Title: "Go 1.23 is released", Content: "Release notes", Link: "http://example.com/1"},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id/revisions?id=1", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []Revision
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Len(t, response, 1, "There should be one previous version")
+	assert.Equal(t, "Go 1.22 is released", response[0].Title, "The previous title should be kept")
+	assert.Equal(t, []diff.Op{
+		{Type: diff.Equal, Text: "Go"},
+		{Type: diff.Delete, Text: "1.22"},
+		{Type: diff.Insert, Text: "1.23"},
+		{Type: diff.Equal, Text: "is released"},
+	}, response[0].TitleDiff)
+}
+
+func TestFilterPostsByKeyword(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Goroutines", Link: "http://example.com/1", Keywords: []string{"goroutines", "runtime"}},
+		{Title: "Generics", Link: "http://example.com/2", Keywords: []string{"generics"}},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/filter?page=1&keyword=Runtime", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response paginate.Paginate
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Len(t, response.Posts, 1, "Only the post with the keyword should be returned")
+	assert.Equal(t, "Goroutines", response.Posts[0].Title)
+}
+
+func TestPostsByLang(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Вышел Go 1.22", Link: "http://example.com/1", Lang: "ru"},
+		{Title: "Go 1.22 is released", Link: "http://example.com/2", Lang: "en"},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news?page=1&lang=en", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response paginate.Paginate
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Len(t, response.Posts, 1, "Only the post in the language should be returned")
+	assert.Equal(t, "Go 1.22 is released", response.Posts[0].Title)
+
+	req = httptest.NewRequest(http.MethodGet, "/news?page=1&lang=en&tag=go", nil)
+	w = httptest.NewRecorder()
+	api.Router().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "The tag and the language can't be combined")
+}
+
+func TestRelated(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Title: "Go 1.22 is released", PubTime: 1000, Link: "http://example.com/1", Tags: []string{"go"}, Keywords: []string{"loops", "release"}},
+		{Title: "Loop variables in Go", PubTime: 2000, Link: "http://example.com/2", Tags: []string{"go"}, Keywords: []string{"loops"}},
+		{Title: "Go turns 15", PubTime: 3000, Link: "http://example.com/3", Tags: []string{"go"}},
+		{Title: "Rust 1.76 is released", PubTime: 4000, Link: "http://example.com/4", Tags: []string{"rust"}},
+	})
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id/related?id=1", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []storage.Post
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if assert.Len(t, response, 2, "Only the posts sharing tags or keywords should be returned") {
+		assert.Equal(t, "Loop variables in Go", response[0].Title, "The post sharing more tags should be first")
+		assert.Equal(t, "Go turns 15", response[1].Title)
+	}
+}
+
+func TestRelatedNotFound(t *testing.T) {
+	db, _ := memdb.New()
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id/related?id=42", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRelatedInvalidLimit(t *testing.T) {
+	db, _ := memdb.New()
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id/related?id=1&limit=100", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetPostNotFound(t *testing.T) {
+	db, _ := memdb.New()
+	api := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/news/id?id=42", nil)
+	w := httptest.NewRecorder()
+
+	api.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
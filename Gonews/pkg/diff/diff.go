@@ -0,0 +1,107 @@
+package diff
+
+import "strings"
+
+// Operation types of a diff.
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// maxCells limits the size of the table of the longest common subsequence,
+// which takes time and memory proportional to the product of the numbers of words.
+const maxCells = 1 << 22
+
+// Op is a run of words that are kept, inserted or deleted
+// when going from the old text to the new one.
+type Op struct {
+	Type string
+	Text string
+}
+
+// Words returns a word-level diff between the old and the new text,
+// computed from their longest common subsequence of words.
+// The common prefix and suffix are matched first, so a local edit of a long text is cheap.
+// If the rest of the texts is too long to compare, more than maxCells pairs of words,
+// it is reported as deleted and inserted as a whole.
+// Adjacent words of the same operation type are merged into one Op.
+func Words(old, new string) []Op {
+	a, b := strings.Fields(old), strings.Fields(new)
+
+	var ops []Op
+	add := func(typ, word string) {
+		if n := len(ops); n > 0 && ops[n-1].Type == typ {
+			ops[n-1].Text += " " + word
+			return
+		}
+		ops = append(ops, Op{Type: typ, Text: word})
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		add(Equal, a[prefix])
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if len(midA)*len(midB) > maxCells {
+		for _, w := range midA {
+			add(Delete, w)
+		}
+		for _, w := range midB {
+			add(Insert, w)
+		}
+	} else {
+		lcsOps(midA, midB, add)
+	}
+
+	for _, w := range a[len(a)-suffix:] {
+		add(Equal, w)
+	}
+	return ops
+}
+
+// lcsOps reports the operations going from a to b along their longest common subsequence.
+func lcsOps(a, b []string, add func(typ, word string)) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add(Equal, a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add(Delete, a[i])
+			i++
+		default:
+			add(Insert, b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add(Delete, a[i])
+	}
+	for ; j < len(b); j++ {
+		add(Insert, b[j])
+	}
+}
@@ -0,0 +1,87 @@
+package diff
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []Op
+	}{
+		{
+			name: "no changes",
+			old:  "Go 1.22 is released",
+			new:  "Go 1.22 is released",
+			want: []Op{{Type: Equal, Text: "Go 1.22 is released"}},
+		},
+		{
+			name: "replaced word",
+			old:  "Go 1.22 is released",
+			new:  "Go 1.23 is released",
+			want: []Op{
+				{Type: Equal, Text: "Go"},
+				{Type: Delete, Text: "1.22"},
+				{Type: Insert, Text: "1.23"},
+				{Type: Equal, Text: "is released"},
+			},
+		},
+		{
+			name: "appended sentence",
+			old:  "Go is released.",
+			new:  "Go is released. Update now.",
+			want: []Op{
+				{Type: Equal, Text: "Go is released."},
+				{Type: Insert, Text: "Update now."},
+			},
+		},
+		{
+			name: "empty old text",
+			old:  "",
+			new:  "New text",
+			want: []Op{{Type: Insert, Text: "New text"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Words(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordsLongTexts(t *testing.T) {
+	words := func(prefix string, n int) string {
+		w := make([]string, n)
+		for i := range w {
+			w[i] = prefix + strconv.Itoa(i)
+		}
+		return strings.Join(w, " ")
+	}
+
+	// A local edit of a long text is found next to the common prefix and suffix.
+	long := words("w", 10000)
+	got := Words("start "+long+" end", "begin "+long+" end")
+	want := []Op{
+		{Type: Delete, Text: "start"},
+		{Type: Insert, Text: "begin"},
+		{Type: Equal, Text: long + " end"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words() of a local edit = %d ops, want %d", len(got), len(want))
+	}
+
+	// Long texts without common words are replaced as a whole.
+	old, new := words("a", 5000), words("b", 5000)
+	got = Words(old, new)
+	want = []Op{{Type: Delete, Text: old}, {Type: Insert, Text: new}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words() of rewritten texts = %d ops, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,217 @@
+package keywords
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+)
+
+const (
+	// minWordLen is the minimal length of a keyword in letters.
+	minWordLen = 3
+	// titleWeight is how many times a word in the title counts compared to the content.
+	titleWeight = 3
+	// maxSeen is how many links the extractor remembers, see Extractor.
+	maxSeen = 100000
+	// seedBatch is how many stored posts Seed reads at once.
+	seedBatch = 500
+)
+
+// Extractor picks the keywords of posts by TF-IDF over the corpus of posts it has seen.
+// Document frequencies are kept per stem, and every link is counted once,
+// so polling the same feed again doesn't skew them.
+// Only the last maxSeen links are remembered: a feed doesn't repeat older posts.
+type Extractor struct {
+	m     sync.Mutex
+	limit int
+	docs  int
+	df    map[string]int
+	seen  map[string]bool
+	// links holds the remembered links in a ring, next is the position of the oldest one.
+	links []string
+	next  int
+}
+
+// New creates an extractor returning at most limit keywords per post.
+func New(limit int) *Extractor {
+	return &Extractor{
+		limit: limit,
+		df:    make(map[string]int),
+		seen:  make(map[string]bool),
+	}
+}
+
+// Seed adds the newest stored posts, at most maxSeen of them, to the corpus,
+// so that the document frequencies survive restarts.
+// It stops at a batch of posts that are all seen already, since a storage
+// that doesn't paginate its posts returns the same ones again.
+// It makes the extractor seeded by the pipeline, see pipeline.Seeder.
+func (e *Extractor) Seed(db storage.Interface) error {
+	for offset := 0; offset < maxSeen; offset += seedBatch {
+		posts, err := db.Posts(offset, seedBatch)
+		if err != nil {
+			return err
+		}
+		if e.add(posts) == 0 || len(posts) < seedBatch {
+			break
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the pipeline stage
+func (e *Extractor) Name() string { return "keywords" }
+
+// Process adds the posts to the corpus and sets their keywords.
+// It makes the extractor a pipeline stage.
+func (e *Extractor) Process(posts []storage.Post) ([]storage.Post, error) {
+	e.Add(posts)
+	for i := range posts {
+		posts[i].Keywords = e.Extract(posts[i])
+	}
+	return posts, nil
+}
+
+// Add adds the posts not seen before to the corpus.
+func (e *Extractor) Add(posts []storage.Post) {
+	e.add(posts)
+}
+
+// add adds the posts not seen before to the corpus and returns their number.
+func (e *Extractor) add(posts []storage.Post) int {
+	e.m.Lock()
+	defer e.m.Unlock()
+	var added int
+	for _, p := range posts {
+		if e.seen[p.Link] {
+			continue
+		}
+		e.remember(p.Link)
+		e.docs++
+		added++
+		for stem := range terms(p) {
+			e.df[stem]++
+		}
+	}
+	return added
+}
+
+// remember adds the link to the seen ones, forgetting the oldest one
+// if there are maxSeen of them. The caller must hold the lock.
+func (e *Extractor) remember(link string) {
+	e.seen[link] = true
+	if len(e.links) < maxSeen {
+		e.links = append(e.links, link)
+		return
+	}
+	delete(e.seen, e.links[e.next])
+	e.links[e.next] = link
+	e.next = (e.next + 1) % maxSeen
+}
+
+// Extract returns the keywords of the post, the most relevant first.
+// Each keyword is the most frequent form of its stem in the post.
+func (e *Extractor) Extract(p storage.Post) []string {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	type scored struct {
+		word  string
+		score float64
+	}
+	var res []scored
+	for stem, t := range terms(p) {
+		idf := math.Log(float64(e.docs+1)/float64(e.df[stem]+1)) + 1
+		res = append(res, scored{word: t.form(), score: float64(t.count) * idf})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		return res[i].word < res[j].word
+	})
+
+	keywords := make([]string, 0, e.limit)
+	for _, s := range res {
+		if len(keywords) == e.limit {
+			break
+		}
+		keywords = append(keywords, s.word)
+	}
+	return keywords
+}
+
+// term is a stem occurring in a post along with the forms it occurs in
+type term struct {
+	count int
+	forms map[string]int
+}
+
+// form returns the most frequent form of the term
+func (t term) form() string {
+	var best string
+	for f, n := range t.forms {
+		if n > t.forms[best] || (n == t.forms[best] && f < best) {
+			best = f
+		}
+	}
+	return best
+}
+
+// terms splits the title and the content of the post into stemmed terms,
+// dropping stop words, numbers and short words.
+func terms(p storage.Post) map[string]term {
+	res := make(map[string]term)
+	add := func(text string, weight int) {
+		for _, w := range splitWords(text) {
+			if len([]rune(w)) < minWordLen || stopwords[w] || isNumber(w) {
+				continue
+			}
+			stem := Stem(w)
+			t, ok := res[stem]
+			if !ok {
+				t.forms = make(map[string]int)
+			}
+			t.count += weight
+			t.forms[w]++
+			res[stem] = t
+		}
+	}
+	add(p.Title, titleWeight)
+	add(p.Content, 1)
+	return res
+}
+
+// Stems splits the text into the stems of its words, dropping stop words,
+// numbers and short words. The stems are returned in the order of the words.
+func Stems(text string) []string {
+	var stems []string
+	for _, w := range splitWords(text) {
+		if len([]rune(w)) < minWordLen || stopwords[w] || isNumber(w) {
+			continue
+		}
+		stems = append(stems, Stem(w))
+	}
+	return stems
+}
+
+// splitWords splits the text into lower-cased words made of letters and digits
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// isNumber reports whether the word has no letters
+func isNumber(w string) bool {
+	for _, r := range w {
+		if unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
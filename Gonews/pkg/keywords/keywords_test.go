@@ -0,0 +1,135 @@
+package keywords
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/storage/memdb"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{word: "горутины", want: "горутин"},
+		{word: "горутинами", want: "горутин"},
+		{word: "горутина", want: "горутин"},
+		{word: "channels", want: "channel"},
+		{word: "testing", want: "test"},
+		{word: "go", want: "go"},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.want {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	corpus := []storage.Post{
+		{Link: "1", Title: "Горутины в Go", Content: "Горутины и каналы: как работают горутины в рантайме."},
+		{Link: "2", Title: "Новый релиз Go", Content: "Вышел релиз Go, в релизе много изменений."},
+		{Link: "3", Title: "Каналы в Go", Content: "Каналы помогают горутинам обмениваться данными."},
+	}
+	e := New(2)
+	posts, err := e.Process(corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := posts[0].Keywords, []string{"горутины", "работают"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keywords = %v, want %v", got, want)
+	}
+	if got, want := posts[1].Keywords, []string{"релиз", "новый"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keywords = %v, want %v", got, want)
+	}
+	for _, p := range posts {
+		for _, k := range p.Keywords {
+			if stopwords[k] {
+				t.Errorf("stop word %q extracted as a keyword", k)
+			}
+		}
+	}
+}
+
+func TestAddCountsLinksOnce(t *testing.T) {
+	e := New(5)
+	post := storage.Post{Link: "1", Title: "Go generics", Content: "Generics in Go"}
+	e.Add([]storage.Post{post})
+	e.Add([]storage.Post{post})
+
+	if e.docs != 1 {
+		t.Errorf("docs = %d after adding the same post twice, want 1", e.docs)
+	}
+	if e.df[Stem("generics")] != 1 {
+		t.Errorf("df = %d after adding the same post twice, want 1", e.df[Stem("generics")])
+	}
+}
+
+func TestSeed(t *testing.T) {
+	db, _ := memdb.New()
+	db.AddPosts([]storage.Post{
+		{Link: "1", Title: "Go generics", Content: "Generics in Go"},
+		{Link: "2", Title: "Go release", Content: "A new release of Go"},
+	})
+	e := New(5)
+	if err := e.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	if e.docs != 2 {
+		t.Errorf("docs = %d after seeding from 2 stored posts, want 2", e.docs)
+	}
+	e.Add([]storage.Post{{Link: "1", Title: "Go generics", Content: "Generics in Go"}})
+	if e.docs != 2 {
+		t.Errorf("docs = %d after adding a stored post again, want 2", e.docs)
+	}
+}
+
+func TestSeenIsBounded(t *testing.T) {
+	e := New(5)
+	for i := 0; i <= maxSeen; i++ {
+		e.remember(strconv.Itoa(i))
+	}
+	if len(e.seen) != maxSeen {
+		t.Errorf("%d links remembered, want %d", len(e.seen), maxSeen)
+	}
+	if e.seen["0"] || !e.seen[strconv.Itoa(maxSeen)] {
+		t.Error("the oldest link should be forgotten first")
+	}
+}
+
+// countingDB counts the calls of Posts
+type countingDB struct {
+	storage.Interface
+	calls int
+}
+
+func (db *countingDB) Posts(offset, limit int) ([]storage.Post, error) {
+	db.calls++
+	return db.Interface.Posts(offset, limit)
+}
+
+func TestSeedStopsOnSeenPosts(t *testing.T) {
+	mem, _ := memdb.New()
+	posts := make([]storage.Post, seedBatch+100)
+	for i := range posts {
+		posts[i] = storage.Post{Link: strconv.Itoa(i), Title: "Go", Content: "Go"}
+	}
+	mem.AddPosts(posts)
+
+	// memdb returns all its posts whatever the offset
+	db := &countingDB{Interface: mem}
+	e := New(5)
+	if err := e.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	if e.docs != len(posts) {
+		t.Errorf("docs = %d after seeding, want %d", e.docs, len(posts))
+	}
+	if db.calls != 2 {
+		t.Errorf("Posts() called %d times, want 2", db.calls)
+	}
+}
@@ -0,0 +1,50 @@
+package keywords
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minStemLen is the minimal number of letters left after stripping a suffix.
+const minStemLen = 3
+
+// ruSuffixes are Russian inflectional endings, the longest first.
+var ruSuffixes = []string{
+	"ившись", "ывшись", "иями", "ями", "ами", "ией", "иям", "ием", "ого", "его", "ому", "ему",
+	"ыми", "ими", "ешь", "ете", "ишь", "ите", "ать", "ять", "ить", "еть", "ний", "ние", "ния",
+	"ях", "ах", "ам", "ям", "ом", "ем", "ой", "ей", "ий", "ый", "ая", "яя", "ое", "ее", "ые",
+	"ие", "ых", "их", "ую", "юю", "ть", "ла", "ли", "ло", "ет", "ют", "ут", "ит", "ат", "ят",
+	"ов", "ев", "ия", "ью",
+	"а", "я", "о", "е", "и", "ы", "у", "ю", "ь", "й",
+}
+
+// enSuffixes are English inflectional and derivational endings, the longest first.
+var enSuffixes = []string{
+	"ational", "ization", "fulness", "ousness", "iveness", "ations", "ation", "ments", "ment",
+	"ness", "ingly", "edly", "able", "ible", "ings", "ing", "ers", "ies", "ied", "ed", "er",
+	"es", "ly", "s",
+}
+
+// Stem reduces a lower-cased word to its stem by stripping the longest known
+// Russian or English ending, so that different forms of a word are counted together.
+// It is a light stemmer: it never strips an ending if fewer than minStemLen letters remain.
+func Stem(word string) string {
+	suffixes := enSuffixes
+	if isCyrillic(word) {
+		suffixes = ruSuffixes
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(word, s) && len([]rune(word))-len([]rune(s)) >= minStemLen {
+			return strings.TrimSuffix(word, s)
+		}
+	}
+	return word
+}
+
+// isCyrillic reports whether the word starts with a Cyrillic letter
+func isCyrillic(word string) bool {
+	for _, r := range word {
+		return unicode.Is(unicode.Cyrillic, r)
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package keywords
+
+// stopwords are frequent Russian and English words that carry no meaning
+// on their own and are never extracted as keywords.
+var stopwords = toSet(`
+a about above after again against all also am an and any are as at be because been before
+being below between both but by can could did do does doing down during each few for from
+further had has have having he her here hers herself him himself his how i if in into is it
+its itself just let me more most my myself new no nor not now of off on once only or other
+our ours ourselves out over own same she should so some such than that the their theirs them
+themselves then there these they this those through to too under until up use used using very
+via was we were what when where which while who whom why will with would you your yours
+yourself yourselves one two get got like make way well also may might must need still
+read more continue reading
+а без более бы был была были было быть в вам вас весь во вот все всего всех вы где да даже
+для до его ее её если есть еще ещё же за здесь и из или им их к как какая какой когда кто ли
+либо мне может мы на над надо наш не него нее неё нет ни них но ну о об однако он она они оно
+от очень по под при с со так также такой там те тем то того тоже той только том ты у уже хотя
+чего чей чем что чтобы чье чьё эта эти это этот я будет будут можно нужно свой своей своих
+себя свою которые который которая которое которых этом этого этой этих всё всем сам сама
+читать далее
+`)
+
+// toSet splits the whitespace-separated words into a set
+func toSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range splitWords(words) {
+		set[w] = true
+	}
+	return set
+}
@@ -0,0 +1,140 @@
+package lang
+
+import (
+	"embed"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+)
+
+const (
+	// maxN is the length of the longest n-grams in the profiles.
+	maxN = 3
+	// profileSize is the number of the most frequent n-grams kept in a profile.
+	profileSize = 300
+	// minLetters is the minimal number of letters needed to detect the language.
+	minLetters = 10
+)
+
+// Languages supported by the detector.
+const (
+	Russian = "ru"
+	English = "en"
+)
+
+//go:embed profiles/*.txt
+var samples embed.FS
+
+// profiles maps each language to the rank of its n-grams, the most frequent first.
+var profiles = make(map[string]map[string]int)
+
+func init() {
+	for _, l := range []string{Russian, English} {
+		b, err := samples.ReadFile("profiles/" + l + ".txt")
+		if err != nil {
+			panic(err)
+		}
+		profiles[l] = profile(string(b))
+	}
+}
+
+// Detect returns the language of the text or an empty string if the text
+// is too short to tell. It compares the n-gram profile of the text with the profiles
+// of the supported languages by the out-of-place distance (Cavnar and Trenkle)
+// and picks the closest one.
+func Detect(text string) string {
+	var letters int
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minLetters {
+		return ""
+	}
+
+	p := profile(text)
+	best, bestDistance := "", -1
+	for l, lp := range profiles {
+		d := distance(p, lp)
+		if bestDistance < 0 || d < bestDistance || (d == bestDistance && l < best) {
+			best, bestDistance = l, d
+		}
+	}
+	return best
+}
+
+// Stage is the pipeline stage setting the language of posts.
+type Stage struct{}
+
+// Name returns the name of the pipeline stage
+func (Stage) Name() string { return "lang" }
+
+// Process detects the languages of the posts from their titles and contents
+func (Stage) Process(posts []storage.Post) ([]storage.Post, error) {
+	for i := range posts {
+		posts[i].Lang = Detect(posts[i].Title + " " + posts[i].Content)
+	}
+	return posts, nil
+}
+
+// profile returns the ranks of the most frequent 1- to maxN-grams of the text.
+// Words are lower-cased and padded with spaces, so n-grams at word boundaries count too.
+func profile(text string) map[string]int {
+	counts := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		runes := []rune(" " + w + " ")
+		for n := 1; n <= maxN; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				if g := string(runes[i : i+n]); g != " " {
+					counts[g]++
+				}
+			}
+		}
+	}
+
+	grams := make([]string, 0, len(counts))
+	for g := range counts {
+		grams = append(grams, g)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > profileSize {
+		grams = grams[:profileSize]
+	}
+
+	ranks := make(map[string]int, len(grams))
+	for i, g := range grams {
+		ranks[g] = i
+	}
+	return ranks
+}
+
+// distance is the out-of-place distance between the profile of a text
+// and the profile of a language: the sum of rank differences of the n-grams,
+// with the maximal penalty for n-grams missing from the language profile.
+func distance(text, language map[string]int) int {
+	var d int
+	for g, r := range text {
+		lr, ok := language[g]
+		if !ok {
+			d += profileSize
+			continue
+		}
+		if r > lr {
+			d += r - lr
+		} else {
+			d += lr - r
+		}
+	}
+	return d
+}
@@ -0,0 +1,34 @@
+package lang
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "russian",
+			text: "Команда разработчиков выпустила новую версию языка, в которой исправлена семантика переменных цикла.",
+			want: Russian,
+		},
+		{
+			name: "english",
+			text: "The development team has released a new version of the language that fixes the semantics of loop variables.",
+			want: English,
+		},
+		{
+			name: "too short",
+			text: "Go 1.22",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.text); got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_tags.sql":     {Data: []byte("CREATE TABLE tags (id SERIAL PRIMARY KEY);")},
+		"0001_create_posts.sql": {Data: []byte("CREATE TABLE posts (id SERIAL PRIMARY KEY);")},
+		"0010_add_source.sql":   {Data: []byte("ALTER TABLE posts ADD COLUMN source TEXT;")},
+		"README.md":             {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("got %d migrations, want 3", len(migrations))
+	}
+	for i, want := range []int{1, 2, 10} {
+		if migrations[i].Version != want {
+			t.Errorf("migrations[%d].Version = %d, want %d", i, migrations[i].Version, want)
+		}
+	}
+	if migrations[0].Name != "0001_create_posts" {
+		t.Errorf("migrations[0].Name = %q, want %q", migrations[0].Name, "0001_create_posts")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"no version": {
+			"create_posts.sql": {Data: []byte("")},
+		},
+		"duplicate version": {
+			"0001_create_posts.sql": {Data: []byte("")},
+			"1_create_tags.sql":     {Data: []byte("")},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(fsys); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package pipeline runs the posts parsed from the feeds through ordered stages before they are stored:
+// normalize, dedupe, filter, then the enrichment of the posts, which is split into
+// the lang, keywords and summary stages, and finally store.
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/suxrobshukurov/gonews/pkg/keywords"
+	"github.com/suxrobshukurov/gonews/pkg/lang"
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/summary"
+)
+
+// Stage is a single step of the ingestion pipeline.
+// Process receives the posts left by the previous stage and returns
+// the posts to pass to the next one, so a stage can modify, drop or store them.
+type Stage interface {
+	Name() string
+	Process([]storage.Post) ([]storage.Post, error)
+}
+
+// Seeder is implemented by the stages that need the stored posts before the first run,
+// such as the keywords stage, whose document frequencies are built from all posts.
+type Seeder interface {
+	Seed(storage.Interface) error
+}
+
+// Pipeline runs the parsed posts through an ordered list of stages.
+type Pipeline struct {
+	stages []Stage
+}
+
+// Config configures the pipeline built by Build.
+// Stages lists the names of the stages in the order they run,
+// the rest of the fields configure individual stages.
+type Config struct {
+	Stages    []string      `json:"stages"`
+	Blocklist []string      `json:"blocklist"`
+	Keywords  int           `json:"keywords"`
+	Summary   SummaryConfig `json:"summary"`
+}
+
+// SummaryConfig configures the summary stage.
+// Contents shorter than MinLength letters are used as their own summary.
+// Summaries are at most MaxLength letters long.
+type SummaryConfig struct {
+	Sentences int `json:"sentences"`
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+}
+
+// DefaultStages are used when the configuration lists no stages.
+var DefaultStages = []string{"normalize", "dedupe", "filter", "lang", "keywords", "summary", "store"}
+
+// Defaults of the stage settings used when the configuration doesn't set them.
+const (
+	// defaultKeywords is the number of keywords extracted per post.
+	defaultKeywords = 5
+	// defaultSummarySentences is the number of sentences in a summary.
+	defaultSummarySentences = 3
+	// defaultSummaryMinLength is the content length in letters starting from which
+	// the content is summarized instead of being used as its own summary.
+	defaultSummaryMinLength = 400
+	// defaultSummaryMaxLength is the maximum length of a summary in letters.
+	defaultSummaryMaxLength = 600
+)
+
+// constructors builds the stages by their names.
+var constructors = map[string]func(Config, storage.Interface) Stage{
+	"normalize": func(Config, storage.Interface) Stage { return Normalize{} },
+	"dedupe":    func(Config, storage.Interface) Stage { return Dedupe{} },
+	"filter":    func(c Config, _ storage.Interface) Stage { return NewFilter(c.Blocklist) },
+	"lang":      func(Config, storage.Interface) Stage { return lang.Stage{} },
+	"keywords":  func(c Config, _ storage.Interface) Stage { return newKeywords(c) },
+	"summary":   func(c Config, _ storage.Interface) Stage { return newSummary(c) },
+	"store":     func(_ Config, db storage.Interface) Stage { return NewStore(db) },
+}
+
+// newKeywords creates the keyword extraction stage
+func newKeywords(c Config) Stage {
+	limit := c.Keywords
+	if limit <= 0 {
+		limit = defaultKeywords
+	}
+	return keywords.New(limit)
+}
+
+// newSummary creates the summary stage
+func newSummary(c Config) Stage {
+	sentences, minLength, maxLength := c.Summary.Sentences, c.Summary.MinLength, c.Summary.MaxLength
+	if sentences <= 0 {
+		sentences = defaultSummarySentences
+	}
+	if minLength <= 0 {
+		minLength = defaultSummaryMinLength
+	}
+	if maxLength <= 0 {
+		maxLength = defaultSummaryMaxLength
+	}
+	return summary.New(sentences, minLength, maxLength)
+}
+
+// New creates a pipeline running the given stages in order.
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Build composes a pipeline from the configuration and seeds its stages, see Seeder.
+// It returns an error if a stage is unknown, can't be seeded or if there is no store stage,
+// since the posts would never reach the database.
+func Build(c Config, db storage.Interface) (*Pipeline, error) {
+	names := c.Stages
+	if len(names) == 0 {
+		names = DefaultStages
+	}
+
+	var stages []Stage
+	var hasStore bool
+	for _, name := range names {
+		constructor, ok := constructors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pipeline stage %q", name)
+		}
+		stage := constructor(c, db)
+		if seeder, ok := stage.(Seeder); ok {
+			if err := seeder.Seed(db); err != nil {
+				return nil, fmt.Errorf("can't seed pipeline stage %q: %w", name, err)
+			}
+		}
+		stages = append(stages, stage)
+		hasStore = hasStore || name == "store"
+	}
+	if !hasStore {
+		return nil, fmt.Errorf("pipeline has no store stage")
+	}
+	return New(stages...), nil
+}
+
+// Run passes the posts through all stages in order and returns the posts
+// left after the last one. It stops at the first stage that fails.
+func (p *Pipeline) Run(posts []storage.Post) ([]storage.Post, error) {
+	var err error
+	for _, s := range p.stages {
+		if len(posts) == 0 {
+			break
+		}
+		posts, err = s.Process(posts)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s failed: %w", s.Name(), err)
+		}
+	}
+	return posts, nil
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/storage/memdb"
+)
+
+// failing is a stage that always fails
+type failing struct{}
+
+func (failing) Name() string { return "failing" }
+
+func (failing) Process([]storage.Post) ([]storage.Post, error) {
+	return nil, errors.New("boom")
+}
+
+func TestBuild(t *testing.T) {
+	db, _ := memdb.New()
+
+	if _, err := Build(Config{}, db); err != nil {
+		t.Fatalf("Build() with default stages failed: %v", err)
+	}
+	if _, err := Build(Config{Stages: []string{"normalize", "unknown", "store"}}, db); err == nil {
+		t.Fatal("Build() should fail on an unknown stage")
+	}
+	if _, err := Build(Config{Stages: []string{"normalize", "dedupe"}}, db); err == nil {
+		t.Fatal("Build() should fail without a store stage")
+	}
+}
+
+func TestRun(t *testing.T) {
+	db, _ := memdb.New()
+	p, err := Build(Config{Blocklist: []string{"Best Casino"}}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []storage.Post{
+		{Title: "  Go   1.22 ", Content: "Release", Link: "http://example.com/1 ", Tags: []string{"Golang"}},
+		{Title: "Go 1.22", Content: "Duplicate", Link: "http://example.com/1"},
+		{Title: "Best\n  casino", Content: "Spam", Link: "http://example.com/2"},
+		{Title: " ", Content: "No title", Link: "http://example.com/3"},
+		{Title: "No link", Content: "Dropped"},
+	}
+	stored, err := p.Run(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("Run() passed %d posts, want 1: %v", len(stored), stored)
+	}
+
+	count, _ := db.Count()
+	if count != 1 {
+		t.Fatalf("%d posts stored, want 1", count)
+	}
+	post, _ := db.PostByID(1)
+	if post.Link != "http://example.com/1" || post.Tags[0] != "go" {
+		t.Fatalf("post was not normalized: %+v", post)
+	}
+	if post.Title != "  Go   1.22 " {
+		t.Fatalf("title = %q, want it stored as the source sent it", post.Title)
+	}
+
+	// Running the same posts again changes nothing, so no revision is saved.
+	p.Run([]storage.Post{{Title: "  Go   1.22 ", Content: "Release", Link: "http://example.com/1", Tags: []string{"Golang"}}})
+	if revisions, _ := db.Revisions(1); len(revisions) != 0 {
+		t.Fatalf("%d revisions saved for an unchanged post, want 0", len(revisions))
+	}
+}
+
+func TestRunStopsOnError(t *testing.T) {
+	db, _ := memdb.New()
+	p := New(Normalize{}, failing{}, NewStore(db))
+
+	if _, err := p.Run([]storage.Post{{Title: "Go", Link: "1"}}); err == nil {
+		t.Fatal("Run() should return the error of the failing stage")
+	}
+	if count, _ := db.Count(); count != 0 {
+		t.Fatalf("%d posts stored after a failed stage, want 0", count)
+	}
+}
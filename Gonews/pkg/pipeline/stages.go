@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"log"
+	"strings"
+
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/tags"
+)
+
+// Normalize trims links and normalizes tags.
+// Titles and contents are stored as the source sends them: rewriting them would make
+// every post stored before differ from its new version and get a revision, see storage.Revision.
+// The stages that compare texts collapse their whitespace themselves, see Filter.
+type Normalize struct{}
+
+// Name returns the name of the stage
+func (Normalize) Name() string { return "normalize" }
+
+// Process normalizes the posts
+func (Normalize) Process(posts []storage.Post) ([]storage.Post, error) {
+	for i := range posts {
+		posts[i].Link = strings.TrimSpace(posts[i].Link)
+		posts[i].Tags = tags.NormalizeAll(posts[i].Tags)
+	}
+	return posts, nil
+}
+
+// Dedupe drops posts without a link and posts repeating a link
+// seen earlier in the batch.
+type Dedupe struct{}
+
+// Name returns the name of the stage
+func (Dedupe) Name() string { return "dedupe" }
+
+// Process drops duplicate posts
+func (Dedupe) Process(posts []storage.Post) ([]storage.Post, error) {
+	seen := make(map[string]bool, len(posts))
+	res := posts[:0]
+	for _, p := range posts {
+		if p.Link == "" || seen[p.Link] {
+			continue
+		}
+		seen[p.Link] = true
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// Filter drops posts with a blank title and posts whose title
+// or content contains a blocked keyword, whatever whitespace separates its words.
+type Filter struct {
+	blocklist []string
+}
+
+// NewFilter creates a filter stage with the given keyword blocklist.
+// Keywords are matched case-insensitively.
+func NewFilter(blocklist []string) *Filter {
+	f := Filter{}
+	for _, k := range blocklist {
+		if k = strings.ToLower(strings.Join(strings.Fields(k), " ")); k != "" {
+			f.blocklist = append(f.blocklist, k)
+		}
+	}
+	return &f
+}
+
+// Name returns the name of the stage
+func (f *Filter) Name() string { return "filter" }
+
+// Process drops blocked posts
+func (f *Filter) Process(posts []storage.Post) ([]storage.Post, error) {
+	res := posts[:0]
+	for _, p := range posts {
+		if strings.TrimSpace(p.Title) == "" || f.blocked(p) {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// blocked reports whether the post contains a blocked keyword
+func (f *Filter) blocked(p storage.Post) bool {
+	text := strings.ToLower(strings.Join(strings.Fields(p.Title+" "+p.Content), " "))
+	for _, k := range f.blocklist {
+		if strings.Contains(text, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store saves the posts to the database and logs how many of them
+// were inserted and updated. It passes the posts on unchanged.
+type Store struct {
+	db storage.Interface
+}
+
+// NewStore creates a store stage saving posts to the database
+func NewStore(db storage.Interface) *Store {
+	return &Store{db: db}
+}
+
+// Name returns the name of the stage
+func (s *Store) Name() string { return "store" }
+
+// Process saves the posts
+func (s *Store) Process(posts []storage.Post) ([]storage.Post, error) {
+	inserted, updated, err := s.db.AddPosts(posts)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("added %d new posts, updated %d posts", inserted, updated)
+	return posts, nil
+}
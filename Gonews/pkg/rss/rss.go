@@ -11,6 +11,7 @@ import (
 	strip "github.com/grokify/html-strip-tags-go"
 
 	"github.com/suxrobshukurov/gonews/pkg/storage"
+	"github.com/suxrobshukurov/gonews/pkg/tags"
 )
 
 // RSS struct for main rss tag
@@ -25,10 +26,11 @@ type Channel struct {
 
 // Item struct for item tag
 type Item struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubTime     string `xml:"pubDate"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	PubTime     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
 }
 
 // Parse Rss feeds
@@ -52,7 +54,9 @@ func ParseRSS(url string) ([]storage.Post, error) {
 		var post storage.Post
 		post.Title = item.Title
 		post.Link = item.Link
+		post.Source = url
 		post.Content = strip.StripTags(item.Description)
+		post.Tags = tags.NormalizeAll(item.Categories)
 		item.PubTime = strings.ReplaceAll(item.PubTime, ",", "")
 		t, err := time.Parse("Mon 2 Jan 2006 15:04:05 -0700", item.PubTime)
 		if err != nil {
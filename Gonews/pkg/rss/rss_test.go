@@ -1,6 +1,9 @@
 package rss
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -15,3 +18,35 @@ func TestParseRSS(t *testing.T) {
 	}
 	t.Log("Lenposts: ", len(posts))
 }
+
+func TestParseRSSCategories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel>
+<item>
+<title>Test Post</title>
+<link>http://example.com/test-post</link>
+<description>&lt;p&gt;Test content&lt;/p&gt;</description>
+<pubDate>Mon, 2 Jan 2006 15:04:05 GMT</pubDate>
+<category>Golang</category>
+<category>Go</category>
+<category>Linux</category>
+</item>
+</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	posts, err := ParseRSS(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want 1", len(posts))
+	}
+	if !reflect.DeepEqual(posts[0].Tags, []string{"go", "linux"}) {
+		t.Fatalf("got tags %v, want [go linux]", posts[0].Tags)
+	}
+	if posts[0].Content != "Test content" {
+		t.Fatalf("got content %q, want %q", posts[0].Content, "Test content")
+	}
+}
@@ -1,22 +1,34 @@
 package memdb
 
 import (
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/suxrobshukurov/gonews/pkg/storage"
 )
 
 type DB struct {
-	m     sync.Mutex
-	id    int
-	store map[int]storage.Post
+	m       sync.Mutex
+	id      int
+	store   map[int]storage.Post
+	links   map[string]int
+	archive map[int]storage.Post
+	// revisions holds the previous versions of posts, the oldest first
+	revisions []storage.Revision
+	// modified is the Unix time of the last change of the posts
+	modified int64
 }
 
 // New creates a new memdb storage
 func New() (*DB, error) {
 	db := DB{
-		id:    1,
-		store: make(map[int]storage.Post),
+		id:       1,
+		store:    make(map[int]storage.Post),
+		links:    make(map[string]int),
+		archive:  make(map[int]storage.Post),
+		modified: time.Now().Unix(),
 	}
 	return &db, nil
 }
@@ -33,32 +45,101 @@ func (db *DB) Posts(offset int, limit int) ([]storage.Post, error) {
 	return posts, nil
 }
 
-// PostByID returns a post by its ID
+// PostByID returns a post by its ID or storage.ErrNotFound if there is no such post
 func (db *DB) PostByID(id int) (storage.Post, error) {
 	db.m.Lock()
 	defer db.m.Unlock()
-	return db.store[id], nil
+	post, ok := db.store[id]
+	if !ok {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return post, nil
 }
 
-// AddPosts adds a list of posts to the database
-func (db *DB) AddPosts(posts []storage.Post) error {
+// PostsByIDs returns the posts with the given IDs in the requested order.
+// IDs that are not found are skipped.
+func (db *DB) PostsByIDs(ids []int) ([]storage.Post, error) {
 	db.m.Lock()
 	defer db.m.Unlock()
+	var posts []storage.Post
+	for _, id := range ids {
+		if p, ok := db.store[id]; ok {
+			posts = append(posts, p)
+		}
+	}
+	return posts, nil
+}
+
+// AddPosts adds a list of posts to the database.
+// Posts with a link that is already stored update the stored post,
+// keeping the replaced version as a revision.
+// It returns the number of inserted and updated posts
+func (db *DB) AddPosts(posts []storage.Post) (int, int, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	var inserted, updated int
+	changed := false
 	for _, p := range posts {
+		if id, ok := db.links[p.Link]; ok {
+			old := db.store[id]
+			if old.Title == p.Title && old.Content == p.Content && old.PubTime == p.PubTime {
+				if !slices.Equal(old.Tags, p.Tags) || !slices.Equal(old.Keywords, p.Keywords) ||
+					old.Summary != p.Summary || old.Lang != p.Lang {
+					changed = true
+				}
+				old.Tags = p.Tags
+				old.Keywords = p.Keywords
+				old.Summary = p.Summary
+				old.Lang = p.Lang
+				db.store[id] = old
+				continue
+			}
+			db.revisions = append(db.revisions, storage.Revision{
+				ID:        len(db.revisions) + 1,
+				PostID:    id,
+				Title:     old.Title,
+				Content:   old.Content,
+				PubTime:   old.PubTime,
+				ChangedAt: time.Now().Unix(),
+			})
+			p.ID = id
+			p.Source = old.Source
+			db.store[id] = p
+			updated++
+			continue
+		}
 		p.ID = db.id
 		db.store[p.ID] = p
+		db.links[p.Link] = p.ID
 		db.id++
+		inserted++
+	}
+	if changed || inserted+updated > 0 {
+		db.touch()
 	}
-	return nil
+	return inserted, updated, nil
+}
+
+// Modified returns the Unix time of the last change of the posts
+func (db *DB) Modified() (int64, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.modified, nil
+}
+
+// touch records a change of the posts, moving the modification time
+// at least a second forward. It must be called with the lock held
+func (db *DB) touch() {
+	db.modified = max(time.Now().Unix(), db.modified+1)
 }
 
 // Filter returns a filtered list of posts
-func (db *DB) Filter(searchStr string, offset int, limit int) ([]storage.Post, error) {
+func (db *DB) Filter(q storage.Query, offset int, limit int) ([]storage.Post, error) {
 	db.m.Lock()
 	defer db.m.Unlock()
 	var posts []storage.Post
 	for _, p := range db.store {
-		if p.Title == searchStr {
+		if matches(p, q) {
 			posts = append(posts, p)
 		}
 	}
@@ -72,15 +153,236 @@ func (db *DB) Count() (int, error) {
 	return len(db.store), nil
 }
 
-// CountOfFilter returns the count of posts matching a search pattern
-func (db *DB) CountOfFilter(str string) (int, error) {
+// CountOfFilter returns the count of posts matching the query
+func (db *DB) CountOfFilter(q storage.Query) (int, error) {
 	db.m.Lock()
 	defer db.m.Unlock()
 	var count int
 	for _, p := range db.store {
-		if p.Title == str {
+		if matches(p, q) {
 			count++
 		}
 	}
 	return count, nil
 }
+
+// matches reports whether the post matches the query.
+// The search matches whole titles.
+func matches(p storage.Post, q storage.Query) bool {
+	if q.Search != "" && p.Title != q.Search {
+		return false
+	}
+	if q.Keyword != "" && !contains(p.Keywords, q.Keyword) {
+		return false
+	}
+	if q.Lang != "" && p.Lang != q.Lang {
+		return false
+	}
+	return true
+}
+
+// Tags returns all tags with the number of posts marked by each of them
+func (db *DB) Tags() ([]storage.Tag, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	counts := make(map[string]int)
+	for _, p := range db.store {
+		for _, t := range p.Tags {
+			counts[t]++
+		}
+		for _, k := range p.Keywords {
+			if !contains(p.Tags, k) {
+				counts[k]++
+			}
+		}
+	}
+	tags := make([]storage.Tag, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, storage.Tag{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// PostsByTag returns a list of posts marked by the tag
+func (db *DB) PostsByTag(tag string, offset int, limit int) ([]storage.Post, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	var posts []storage.Post
+	for _, p := range db.store {
+		if hasTag(p, tag) {
+			posts = append(posts, p)
+		}
+	}
+	return paginate(posts, offset, limit), nil
+}
+
+// CountOfTag returns the count of posts marked by the tag
+func (db *DB) CountOfTag(tag string) (int, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	var count int
+	for _, p := range db.store {
+		if hasTag(p, tag) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// hasTag reports whether the post is marked by the tag,
+// either as an RSS category or as a keyword
+func hasTag(p storage.Post, tag string) bool {
+	return contains(p.Tags, tag) || contains(p.Keywords, tag)
+}
+
+// contains reports whether the list contains the string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// paginate sorts posts by publication time, newest first,
+// and returns at most limit of them starting from offset
+func paginate(posts []storage.Post, offset int, limit int) []storage.Post {
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].PubTime > posts[j].PubTime
+	})
+	if offset >= len(posts) {
+		return nil
+	}
+	posts = posts[offset:]
+	if limit < len(posts) {
+		posts = posts[:limit]
+	}
+	return posts
+}
+
+// ApplyRetention removes the posts that fall out of the retention policy
+// and returns the number of removed posts
+func (db *DB) ApplyRetention(r storage.Retention) (int, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	removed := make(map[int]bool)
+	if r.Before > 0 {
+		for id, p := range db.store {
+			if p.PubTime < r.Before {
+				removed[id] = true
+			}
+		}
+	}
+	if r.KeepPerSource > 0 {
+		sources := make(map[string][]storage.Post)
+		for _, p := range db.store {
+			sources[p.Source] = append(sources[p.Source], p)
+		}
+		for _, posts := range sources {
+			sort.Slice(posts, func(i, j int) bool {
+				if posts[i].PubTime != posts[j].PubTime {
+					return posts[i].PubTime > posts[j].PubTime
+				}
+				return posts[i].ID > posts[j].ID
+			})
+			for i := r.KeepPerSource; i < len(posts); i++ {
+				removed[posts[i].ID] = true
+			}
+		}
+	}
+	for id := range removed {
+		if r.Archive {
+			db.archive[id] = db.store[id]
+		}
+		delete(db.links, db.store[id].Link)
+		delete(db.store, id)
+	}
+	revisions := db.revisions[:0]
+	for _, rev := range db.revisions {
+		if !removed[rev.PostID] {
+			revisions = append(revisions, rev)
+		}
+	}
+	db.revisions = revisions
+	if len(removed) > 0 {
+		db.touch()
+	}
+	return len(removed), nil
+}
+
+// Revisions returns the previous versions of the post, the newest first
+func (db *DB) Revisions(postID int) ([]storage.Revision, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	var revisions []storage.Revision
+	for i := len(db.revisions) - 1; i >= 0; i-- {
+		if db.revisions[i].PostID == postID {
+			revisions = append(revisions, db.revisions[i])
+		}
+	}
+	return revisions, nil
+}
+
+// Related returns at most limit posts published within window seconds of the post
+// that share tags or keywords with it, the ones sharing more of them first.
+// A window of zero or less doesn't limit the publication time.
+// If there is no such post, it returns storage.ErrNotFound
+func (db *DB) Related(postID int, window int64, limit int) ([]storage.Post, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	post, ok := db.store[postID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	own := make(map[string]bool)
+	for _, t := range labels(post) {
+		own[t] = true
+	}
+
+	shared := make(map[int]int)
+	var posts []storage.Post
+	for id, p := range db.store {
+		if id == postID {
+			continue
+		}
+		if window > 0 && (p.PubTime < post.PubTime-window || p.PubTime > post.PubTime+window) {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, t := range labels(p) {
+			if own[t] && !seen[t] {
+				seen[t] = true
+				shared[id]++
+			}
+		}
+		if shared[id] > 0 {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if shared[posts[i].ID] != shared[posts[j].ID] {
+			return shared[posts[i].ID] > shared[posts[j].ID]
+		}
+		return posts[i].PubTime > posts[j].PubTime
+	})
+	if limit < len(posts) {
+		posts = posts[:limit]
+	}
+	return posts, nil
+}
+
+// labels returns the tags and the keywords of the post in a new slice:
+// appending to the stored tags could write into their spare capacity,
+// which other copies of the post share
+func labels(p storage.Post) []string {
+	res := make([]string, 0, len(p.Tags)+len(p.Keywords))
+	res = append(res, p.Tags...)
+	return append(res, p.Keywords...)
+}
@@ -1,6 +1,7 @@
 package memdb
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -28,7 +29,7 @@ func TestMemDB_Posts(t *testing.T) {
 		},
 	}
 
-	err = db.AddPosts(posts)
+	_, _, err = db.AddPosts(posts)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,3 +40,175 @@ func TestMemDB_Posts(t *testing.T) {
 	}
 	t.Log(posts)
 }
+
+func TestMemDB_PostsByTag(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []storage.Post{
+		{Title: "First", PubTime: 1, Link: "1", Tags: []string{"go", "linux"}},
+		{Title: "Second", PubTime: 2, Link: "2", Tags: []string{"go"}},
+		{Title: "Third", PubTime: 3, Link: "3"},
+	}
+	if _, _, err := db.AddPosts(posts); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err = db.PostsByTag("go", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].Title != "Second" {
+		t.Fatalf("PostsByTag() = %v, want 2 posts, newest first", posts)
+	}
+
+	count, err := db.CountOfTag("linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("CountOfTag() = %d, want 1", count)
+	}
+
+	tags, err := db.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []storage.Tag{{Name: "go", Count: 2}, {Name: "linux", Count: 1}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("Tags() = %v, want %v", tags, want)
+	}
+}
+
+func TestMemDB_ApplyRetention(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []storage.Post{
+		{Title: "Old", PubTime: 1, Link: "1", Source: "a"},
+		{Title: "Middle", PubTime: 5, Link: "2", Source: "a"},
+		{Title: "New", PubTime: 10, Link: "3", Source: "a"},
+		{Title: "Other", PubTime: 3, Link: "4", Source: "b"},
+	}
+	if _, _, err := db.AddPosts(posts); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := db.ApplyRetention(storage.Retention{Before: 2, KeepPerSource: 2, Archive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("ApplyRetention() removed %d posts, want 1", removed)
+	}
+
+	removed, err = db.ApplyRetention(storage.Retention{KeepPerSource: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("ApplyRetention() removed %d posts, want 1", removed)
+	}
+
+	count, _ := db.Count()
+	if count != 2 {
+		t.Fatalf("Count() = %d after retention, want 2", count)
+	}
+	if len(db.archive) != 1 || db.archive[1].Title != "Old" {
+		t.Fatalf("archive = %v, want the old post only", db.archive)
+	}
+}
+
+func TestMemDB_AddPostsUpsert(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []storage.Post{
+		{Title: "First", Link: "1"},
+		{Title: "Second", Link: "2"},
+	}
+	inserted, updated, err := db.AddPosts(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 2 || updated != 0 {
+		t.Fatalf("AddPosts() = %d inserted, %d updated, want 2 and 0", inserted, updated)
+	}
+
+	posts[1].Title = "Second, corrected"
+	inserted, updated, err = db.AddPosts(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || updated != 1 {
+		t.Fatalf("AddPosts() = %d inserted, %d updated, want 0 and 1", inserted, updated)
+	}
+
+	post, _ := db.PostByID(2)
+	if post.Title != "Second, corrected" {
+		t.Fatalf("PostByID() title = %q, want the updated one", post.Title)
+	}
+}
+
+func TestMemDB_RelatedKeepsTags(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tags with spare capacity, which appending the keywords would write into
+	tags := append(make([]string, 0, 4), "go")
+	db.AddPosts([]storage.Post{
+		{Title: "Go 1.22", Link: "1", Tags: tags, Keywords: []string{"loops"}},
+		{Title: "Go loops", Link: "2", Tags: []string{"go"}, Keywords: []string{"loops"}},
+	})
+
+	related, err := db.Related(1, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(related) != 1 || related[0].Link != "2" {
+		t.Errorf("Related() = %v, want the post 2", related)
+	}
+	if got := tags[:2][1]; got != "" {
+		t.Errorf("Related() wrote %q into the spare capacity of the tags", got)
+	}
+}
+
+func TestMemDB_Modified(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := storage.Post{Title: "Test Post", Link: "http://example.com/1", Tags: []string{"go"}}
+	modified := func() int64 {
+		m, err := db.Modified()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	start := modified()
+	db.AddPosts([]storage.Post{post})
+	added := modified()
+	if added <= start {
+		t.Errorf("Modified() = %d after adding a post, want more than %d", added, start)
+	}
+
+	db.AddPosts([]storage.Post{post})
+	if got := modified(); got != added {
+		t.Errorf("Modified() = %d after adding the same post, want %d", got, added)
+	}
+
+	post.Tags = []string{"go", "release"}
+	db.AddPosts([]storage.Post{post})
+	if got := modified(); got <= added {
+		t.Errorf("Modified() = %d after retagging the post, want more than %d", got, added)
+	}
+}
@@ -1,6 +1,4 @@
-DROP TABLE IF EXISTS posts;
-
-CREATE TABLE posts (
+CREATE TABLE IF NOT EXISTS posts (
   id SERIAL PRIMARY KEY,
   title TEXT NOT NULL,
   content TEXT NOT NULL,
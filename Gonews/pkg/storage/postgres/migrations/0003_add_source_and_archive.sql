@@ -0,0 +1,11 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS posts_archive (
+  id INTEGER PRIMARY KEY,
+  title TEXT NOT NULL,
+  content TEXT NOT NULL,
+  pub_time INTEGER DEFAULT 0,
+  link TEXT NOT NULL,
+  source TEXT NOT NULL DEFAULT '',
+  archived_at BIGINT NOT NULL DEFAULT extract(epoch from now())
+);
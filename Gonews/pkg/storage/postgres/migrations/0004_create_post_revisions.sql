@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS post_revisions (
+  id SERIAL PRIMARY KEY,
+  post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  content TEXT NOT NULL,
+  pub_time INTEGER DEFAULT 0,
+  changed_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS post_revisions_post_id_idx ON post_revisions (post_id);
@@ -0,0 +1,10 @@
+-- kind is 'category' for RSS categories and 'keyword' for extracted keywords,
+-- rank keeps the order of the tags of a post, the most relevant keyword first.
+ALTER TABLE posts_tags ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'category';
+ALTER TABLE posts_tags ADD COLUMN IF NOT EXISTS rank INTEGER NOT NULL DEFAULT 0;
+
+-- a keyword that is also a category of the post is stored with both kinds
+ALTER TABLE posts_tags DROP CONSTRAINT IF EXISTS posts_tags_pkey;
+ALTER TABLE posts_tags ADD PRIMARY KEY (post_id, tag_id, kind);
+
+CREATE INDEX IF NOT EXISTS posts_tags_tag_id_idx ON posts_tags (tag_id, kind);
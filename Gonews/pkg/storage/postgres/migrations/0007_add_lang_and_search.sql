@@ -0,0 +1,16 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS lang TEXT NOT NULL DEFAULT '';
+
+-- search indexes titles and contents in the full-text configuration of the post language
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+  to_tsvector(
+    CASE lang
+      WHEN 'ru' THEN 'russian'::regconfig
+      WHEN 'en' THEN 'english'::regconfig
+      ELSE 'simple'::regconfig
+    END,
+    title || ' ' || content
+  )
+) STORED;
+
+CREATE INDEX IF NOT EXISTS posts_search_idx ON posts USING GIN (search);
+CREATE INDEX IF NOT EXISTS posts_lang_idx ON posts (lang, pub_time DESC);
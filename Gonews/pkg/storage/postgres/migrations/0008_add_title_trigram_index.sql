@@ -0,0 +1,4 @@
+-- posts_title_trgm_idx serves the substring search in titles, title ILIKE '%...%'
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS posts_title_trgm_idx ON posts USING GIN (title gin_trgm_ops);
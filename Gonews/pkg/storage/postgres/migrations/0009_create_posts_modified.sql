@@ -0,0 +1,8 @@
+-- posts_modified holds the Unix time of the last change of the posts:
+-- a post added, changed or removed. It is sent as the Last-Modified time of the API responses.
+CREATE TABLE IF NOT EXISTS posts_modified (
+  id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+  modified_at BIGINT NOT NULL
+);
+
+INSERT INTO posts_modified (modified_at) VALUES (extract(epoch from now())::BIGINT) ON CONFLICT DO NOTHING;
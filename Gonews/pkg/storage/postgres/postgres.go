@@ -2,12 +2,17 @@ package postgres
 
 import (
 	"context"
+	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/subosito/gotenv"
+	"github.com/suxrobshukurov/gonews/pkg/migrate"
 	"github.com/suxrobshukurov/gonews/pkg/storage"
 )
 
@@ -15,6 +20,39 @@ func init() {
 	gotenv.Load()
 }
 
+// tagsColumn selects the sorted names of the RSS categories of the post
+// from the current row of the posts table as a text array.
+const tagsColumn = `COALESCE((
+			SELECT array_agg(tags.name ORDER BY tags.name)
+			FROM posts_tags
+			JOIN tags ON tags.id = posts_tags.tag_id
+			WHERE posts_tags.post_id = posts.id AND posts_tags.kind = 'category'
+		), '{}')`
+
+// keywordsColumn selects the extracted keywords of the post from the current row
+// of the posts table as a text array, the most relevant first.
+const keywordsColumn = `COALESCE((
+			SELECT array_agg(tags.name ORDER BY posts_tags.rank)
+			FROM posts_tags
+			JOIN tags ON tags.id = posts_tags.tag_id
+			WHERE posts_tags.post_id = posts.id AND posts_tags.kind = 'keyword'
+		), '{}')`
+
+// migrations holds the versioned schema changes applied by Migrate.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// postColumns lists the columns of a post in the order expected by scanPost.
+const postColumns = `id, title, content, summary, pub_time, link, source, lang, ` + tagsColumn + `, ` + keywordsColumn
+
+// scanPost scans a row selected with postColumns into a post.
+func scanPost(row pgx.Row) (storage.Post, error) {
+	var post storage.Post
+	err := row.Scan(&post.ID, &post.Title, &post.Content, &post.Summary, &post.PubTime, &post.Link, &post.Source, &post.Lang, &post.Tags, &post.Keywords)
+	return post, err
+}
+
 type DB struct {
 	pool *pgxpool.Pool
 }
@@ -32,6 +70,16 @@ func New() (*DB, error) {
 	return &DB{pool: p}, nil
 }
 
+// Migrate applies the schema migrations that are not applied yet
+// and returns the number of applied migrations.
+func (db *DB) Migrate() (int, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	return migrate.Up(db.pool, sub)
+}
+
 
 // Posts retrieves posts from the database with context support
 // offset is the number of records to skip
@@ -39,7 +87,7 @@ func New() (*DB, error) {
 // returns a slice of Post and an error if any
 func (db *DB) Posts(offset int, limit int) ([]storage.Post, error) {
 	rows, err := db.pool.Query(context.Background(), `
-		SELECT id, title, content, pub_time, link
+		SELECT `+postColumns+`
 		FROM posts
 		ORDER BY pub_time DESC
 		OFFSET $1 LIMIT $2
@@ -51,8 +99,8 @@ func (db *DB) Posts(offset int, limit int) ([]storage.Post, error) {
 
 	var posts []storage.Post
 	for rows.Next() {
-		var post storage.Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.PubTime, &post.Link); err != nil {
+		post, err := scanPost(rows)
+		if err != nil {
 			return nil, fmt.Errorf("can't scan post: %w", err)
 		}
 		posts = append(posts, post)
@@ -61,49 +109,266 @@ func (db *DB) Posts(offset int, limit int) ([]storage.Post, error) {
 }
 
 // PostByID retrieves a post by its id with context support
-// returns a Post and an error if any
+// returns a Post and an error if any, storage.ErrNotFound if there is no such post
 func (db *DB) PostByID(id int) (storage.Post, error) {
-	var post storage.Post
-	err := db.pool.QueryRow(context.Background(), `
-		SELECT id, title, content, pub_time, link
+	post, err := scanPost(db.pool.QueryRow(context.Background(), `
+		SELECT `+postColumns+`
 		FROM posts
 		WHERE id = $1
-	`, id).Scan(&post.ID, &post.Title, &post.Content, &post.PubTime, &post.Link)
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.Post{}, storage.ErrNotFound
+	}
 	if err != nil {
 		return storage.Post{}, fmt.Errorf("can't get post by id from db: %w", err)
 	}
 	return post, nil
 }
 
+// PostsByIDs retrieves the posts with the given ids in one query.
+// The posts are returned in the order of ids, ids that are not found are skipped.
+func (db *DB) PostsByIDs(ids []int) ([]storage.Post, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT `+postColumns+`
+		FROM posts
+		WHERE id = ANY($1)
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("can't get posts by ids from db: %w", err)
+	}
+	defer rows.Close()
 
-// AddPosts adds a list of posts to the database. It will upsert the posts if they
-// already exist in the database, updating the title, content, and pub_time fields.
-//
-func (db *DB) AddPosts(posts []storage.Post) error {
-	for _, p := range posts {
-		_, err := db.pool.Exec(context.Background(), `
-			INSERT INTO posts (title, content, pub_time, link)
-			VALUES ($1, $2, $3, $4)
-			ON CONFLICT (link) DO UPDATE
-			SET title = EXCLUDED.title, content = EXCLUDED.content, pub_time = EXCLUDED.pub_time
-		`, p.Title, p.Content, p.PubTime, p.Link)
+	found := make(map[int]storage.Post, len(ids))
+	for rows.Next() {
+		post, err := scanPost(rows)
 		if err != nil {
-			return fmt.Errorf("can't insert post in db: %w", err)
+			return nil, fmt.Errorf("can't scan post: %w", err)
 		}
+		found[post.ID] = post
 	}
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var posts []storage.Post
+	for _, id := range ids {
+		if post, ok := found[id]; ok {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+// AddPosts adds a list of posts to the database in a single transaction.
+// The posts are copied to a staging table and upserted from there in one statement:
+// new links are inserted, existing ones are updated if their title, content or pub_time
+// has changed, and exactly these posts have their replaced versions saved to post_revisions.
+// The summary and lang are derived from the content, so they are refreshed on their own
+// when only they change, e.g. after the summary settings change, without a revision.
+// The tags and keywords of each post replace the ones stored for it before.
+// It returns the number of inserted and updated posts, unchanged posts are not counted.
+func (db *DB) AddPosts(posts []storage.Post) (inserted int, updated int, err error) {
+	if len(posts) == 0 {
+		return 0, 0, nil
+	}
+	ctx := context.Background()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE posts_staging (
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			summary TEXT NOT NULL,
+			pub_time INTEGER NOT NULL,
+			link TEXT NOT NULL,
+			source TEXT NOT NULL,
+			lang TEXT NOT NULL
+		) ON COMMIT DROP;
+		CREATE TEMP TABLE posts_tags_staging (
+			link TEXT NOT NULL,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			rank INTEGER NOT NULL
+		) ON COMMIT DROP;
+	`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't create staging tables: %w", err)
+	}
+
+	// the same link may come twice in a batch, the last one wins
+	last := make(map[string]int, len(posts))
+	for i, p := range posts {
+		last[p.Link] = i
+	}
+	var postRows, tagRows [][]interface{}
+	for i, p := range posts {
+		if last[p.Link] != i {
+			continue
+		}
+		postRows = append(postRows, []interface{}{p.Title, p.Content, p.Summary, p.PubTime, p.Link, p.Source, p.Lang})
+		for i, t := range p.Tags {
+			tagRows = append(tagRows, []interface{}{p.Link, t, "category", i})
+		}
+		// a keyword that is also a category of the post is stored with both kinds
+		for i, k := range p.Keywords {
+			tagRows = append(tagRows, []interface{}{p.Link, k, "keyword", i})
+		}
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"posts_staging"},
+		[]string{"title", "content", "summary", "pub_time", "link", "source", "lang"}, pgx.CopyFromRows(postRows))
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't copy posts to staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"posts_tags_staging"},
+		[]string{"link", "name", "kind", "rank"}, pgx.CopyFromRows(tagRows))
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't copy tags to staging table: %w", err)
+	}
+
+	now := time.Now().Unix()
+
+	// keep the versions that are about to be overwritten,
+	// the condition must match the one of the upsert below
+	_, err = tx.Exec(ctx, `
+		INSERT INTO post_revisions (post_id, title, content, pub_time, changed_at)
+		SELECT posts.id, posts.title, posts.content, posts.pub_time, $1
+		FROM posts
+		JOIN posts_staging ON posts_staging.link = posts.link
+		WHERE (posts.title, posts.content, posts.pub_time)
+			IS DISTINCT FROM (posts_staging.title, posts_staging.content, posts_staging.pub_time)
+	`, now)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't save revisions of posts: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		WITH upserted AS (
+			INSERT INTO posts (title, content, summary, pub_time, link, source, lang)
+			SELECT title, content, summary, pub_time, link, source, lang FROM posts_staging
+			ON CONFLICT (link) DO UPDATE
+			SET title = EXCLUDED.title, content = EXCLUDED.content,
+				summary = EXCLUDED.summary, pub_time = EXCLUDED.pub_time, lang = EXCLUDED.lang
+			WHERE (posts.title, posts.content, posts.pub_time)
+				IS DISTINCT FROM (EXCLUDED.title, EXCLUDED.content, EXCLUDED.pub_time)
+			RETURNING xmax = 0 AS inserted
+		)
+		SELECT COUNT(*) FILTER (WHERE inserted), COUNT(*) FILTER (WHERE NOT inserted)
+		FROM upserted
+	`).Scan(&inserted, &updated)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't upsert posts in db: %w", err)
+	}
+
+	summaries, err := tx.Exec(ctx, `
+		UPDATE posts
+		SET summary = posts_staging.summary, lang = posts_staging.lang
+		FROM posts_staging
+		WHERE posts.link = posts_staging.link
+			AND (posts.summary, posts.lang) IS DISTINCT FROM (posts_staging.summary, posts_staging.lang)
+	`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't update summaries of posts: %w", err)
+	}
+
+	// a tag listed twice for a post keeps its first rank
+	var tagsChanged bool
+	err = tx.QueryRow(ctx, `
+		WITH staged AS (
+			SELECT link, name, kind, MIN(rank) AS rank
+			FROM posts_tags_staging
+			GROUP BY link, name, kind
+		), stored AS (
+			SELECT posts.link, tags.name, posts_tags.kind, posts_tags.rank
+			FROM posts_tags
+			JOIN posts ON posts.id = posts_tags.post_id
+			JOIN posts_staging ON posts_staging.link = posts.link
+			JOIN tags ON tags.id = posts_tags.tag_id
+		)
+		SELECT EXISTS (SELECT * FROM staged EXCEPT SELECT * FROM stored)
+			OR EXISTS (SELECT * FROM stored EXCEPT SELECT * FROM staged)
+	`).Scan(&tagsChanged)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't compare tags of posts: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO tags (name)
+		SELECT DISTINCT name FROM posts_tags_staging
+		ON CONFLICT (name) DO NOTHING;
+
+		DELETE FROM posts_tags
+		USING posts, posts_staging
+		WHERE posts_tags.post_id = posts.id AND posts.link = posts_staging.link;
+
+		INSERT INTO posts_tags (post_id, tag_id, kind, rank)
+		SELECT posts.id, tags.id, posts_tags_staging.kind, MIN(posts_tags_staging.rank)
+		FROM posts_tags_staging
+		JOIN posts ON posts.link = posts_tags_staging.link
+		JOIN tags ON tags.name = posts_tags_staging.name
+		GROUP BY posts.id, tags.id, posts_tags_staging.kind;
+	`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't update tags of posts: %w", err)
+	}
+
+	if inserted+updated > 0 || summaries.RowsAffected() > 0 || tagsChanged {
+		if err := touch(ctx, tx, now); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("can't commit posts: %w", err)
+	}
+	return inserted, updated, nil
 }
 
-// Filter retrieves posts matching a search pattern with context support
-func (db *DB) Filter(searchStr string, offset int, limit int) ([]storage.Post, error) {
-	searchPattern := "%" + searchStr + "%"
+// filterCondition selects the posts matching a storage.Query.
+// $1 is the search string, $2 is the keyword, $3 is the language,
+// empty strings match all posts. A post matches the search string if its title
+// contains it or if its title and content match it as a full-text query
+// in the configuration of the post language.
+//
+// Each way to match is a separate query, so that each of them uses its index:
+// the trigram index of the titles and the index of the search column, queried once
+// per language with a constant configuration. The languages and configurations
+// must match the expression of the search column in the migrations.
+// The languages other than the requested one are skipped.
+const filterCondition = `
+		($1 = '' OR id IN (
+			SELECT id FROM posts WHERE title ILIKE '%' || $1 || '%' AND ($3 = '' OR lang = $3)
+			UNION
+			SELECT id FROM posts WHERE $3 IN ('', 'ru') AND lang = 'ru'
+				AND search @@ plainto_tsquery('russian'::regconfig, $1)
+			UNION
+			SELECT id FROM posts WHERE $3 IN ('', 'en') AND lang = 'en'
+				AND search @@ plainto_tsquery('english'::regconfig, $1)
+			UNION
+			SELECT id FROM posts WHERE $3 NOT IN ('ru', 'en') AND lang NOT IN ('ru', 'en')
+				AND ($3 = '' OR lang = $3)
+				AND search @@ plainto_tsquery('simple'::regconfig, $1)
+		))
+		AND ($2 = '' OR id IN (
+			SELECT posts_tags.post_id
+			FROM posts_tags
+			JOIN tags ON tags.id = posts_tags.tag_id
+			WHERE posts_tags.kind = 'keyword' AND tags.name = $2
+		))
+		AND ($3 = '' OR lang = $3)`
+
+// Filter retrieves posts matching the query with context support
+func (db *DB) Filter(q storage.Query, offset int, limit int) ([]storage.Post, error) {
 	rows, err := db.pool.Query(context.Background(), `
-		SELECT id, title, content, pub_time, link
+		SELECT `+postColumns+`
 		FROM posts
-		WHERE title ILIKE $1
+		WHERE `+filterCondition+`
 		ORDER BY pub_time DESC
-		OFFSET $2 LIMIT $3
-	`, searchPattern, offset, limit)
+		OFFSET $4 LIMIT $5
+	`, q.Search, q.Keyword, q.Lang, offset, limit)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve filtered posts from db: %w", err)
 	}
@@ -111,8 +376,8 @@ func (db *DB) Filter(searchStr string, offset int, limit int) ([]storage.Post, e
 
 	var posts []storage.Post
 	for rows.Next() {
-		var post storage.Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.PubTime, &post.Link); err != nil {
+		post, err := scanPost(rows)
+		if err != nil {
 			return nil, fmt.Errorf("unable to scan post row: %w", err)
 		}
 		posts = append(posts, post)
@@ -132,17 +397,223 @@ func (db *DB) Count() (int, error) {
 	return count, nil
 }
 
-// CountOfFilter returns the count of posts matching a search pattern
+// CountOfFilter returns the count of posts matching the query
 // with context support.
-func (db *DB) CountOfFilter(str string) (int, error) {
-	str = "%" + str + "%"
+func (db *DB) CountOfFilter(q storage.Query) (int, error) {
 	var count int
 	err := db.pool.QueryRow(context.Background(), `
 		SELECT COUNT(*) AS total_rows FROM posts
-		WHERE title ILIKE $1
-	`, str).Scan(&count)
+		WHERE `+filterCondition+`
+	`, q.Search, q.Keyword, q.Lang).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("can't get count from db: %w", err)
 	}
 	return count, nil
 }
+
+// Tags returns all tags with the number of posts marked by each of them,
+// the most popular tags first.
+func (db *DB) Tags() ([]storage.Tag, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT tags.name, COUNT(DISTINCT posts_tags.post_id) AS posts
+		FROM tags
+		JOIN posts_tags ON posts_tags.tag_id = tags.id
+		GROUP BY tags.name
+		ORDER BY posts DESC, tags.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("can't get tags from db: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []storage.Tag
+	for rows.Next() {
+		var tag storage.Tag
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, fmt.Errorf("can't scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// PostsByTag retrieves posts marked by the tag with context support
+func (db *DB) PostsByTag(tag string, offset int, limit int) ([]storage.Post, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT `+postColumns+`
+		FROM posts
+		WHERE id IN (
+			SELECT posts_tags.post_id
+			FROM posts_tags
+			JOIN tags ON tags.id = posts_tags.tag_id
+			WHERE tags.name = $1
+		)
+		ORDER BY pub_time DESC
+		OFFSET $2 LIMIT $3
+	`, tag, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("can't get posts by tag from db: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []storage.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("can't scan post: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// CountOfTag returns the count of posts marked by the tag
+// with context support.
+func (db *DB) CountOfTag(tag string) (int, error) {
+	var count int
+	err := db.pool.QueryRow(context.Background(), `
+		SELECT COUNT(DISTINCT posts_tags.post_id) AS total_rows
+		FROM posts_tags
+		JOIN tags ON tags.id = posts_tags.tag_id
+		WHERE tags.name = $1
+	`, tag).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("can't get count from db: %w", err)
+	}
+	return count, nil
+}
+
+// ApplyRetention removes the posts that fall out of the retention policy
+// in a single statement and returns the number of removed posts.
+// If the policy asks to archive posts, they are copied to the posts_archive table
+// before being deleted, their tags are dropped along with them.
+func (db *DB) ApplyRetention(r storage.Retention) (int, error) {
+	var count int
+	err := db.pool.QueryRow(context.Background(), `
+		WITH removed AS (
+			DELETE FROM posts
+			WHERE ($1 > 0 AND pub_time < $1)
+			OR ($2 > 0 AND id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY source ORDER BY pub_time DESC, id DESC) AS n
+					FROM posts
+				) AS ranked
+				WHERE n > $2
+			))
+			RETURNING id, title, content, pub_time, link, source
+		), archived AS (
+			INSERT INTO posts_archive (id, title, content, pub_time, link, source)
+			SELECT id, title, content, pub_time, link, source FROM removed
+			WHERE $3
+			ON CONFLICT (id) DO NOTHING
+		), touched AS (
+			UPDATE posts_modified SET modified_at = GREATEST($4, modified_at + 1)
+			WHERE EXISTS (SELECT 1 FROM removed)
+		)
+		SELECT COUNT(*) FROM removed
+	`, r.Before, r.KeepPerSource, r.Archive, time.Now().Unix()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("can't apply retention policy: %w", err)
+	}
+	return count, nil
+}
+
+// Modified returns the Unix time of the last change of the posts, see storage.Interface.
+func (db *DB) Modified() (int64, error) {
+	var modified int64
+	err := db.pool.QueryRow(context.Background(), `
+		SELECT modified_at FROM posts_modified
+	`).Scan(&modified)
+	if err != nil {
+		return 0, fmt.Errorf("can't get modification time from db: %w", err)
+	}
+	return modified, nil
+}
+
+// touch records a change of the posts made at the Unix time now, see Modified.
+func touch(ctx context.Context, tx pgx.Tx, now int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE posts_modified SET modified_at = GREATEST($1, modified_at + 1)
+	`, now)
+	if err != nil {
+		return fmt.Errorf("can't record modification time: %w", err)
+	}
+	return nil
+}
+
+// Revisions retrieves the previous versions of the post, the newest first.
+func (db *DB) Revisions(postID int) ([]storage.Revision, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT id, post_id, title, content, pub_time, changed_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY changed_at DESC, id DESC
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get revisions from db: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []storage.Revision
+	for rows.Next() {
+		var r storage.Revision
+		if err := rows.Scan(&r.ID, &r.PostID, &r.Title, &r.Content, &r.PubTime, &r.ChangedAt); err != nil {
+			return nil, fmt.Errorf("can't scan revision: %w", err)
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Related retrieves at most limit posts published within window seconds of the post
+// that share tags or keywords with it, the ones sharing more of them first.
+// A window of zero or less doesn't limit the publication time.
+// If there is no such post, it returns storage.ErrNotFound.
+func (db *DB) Related(postID int, window int64, limit int) ([]storage.Post, error) {
+	rows, err := db.pool.Query(context.Background(), `
+		SELECT `+postColumns+`
+		FROM posts
+		JOIN (
+			SELECT other.post_id, COUNT(DISTINCT other.tag_id) AS shared
+			FROM posts_tags own
+			JOIN posts_tags other ON other.tag_id = own.tag_id AND other.post_id <> own.post_id
+			WHERE own.post_id = $1
+			GROUP BY other.post_id
+		) related ON related.post_id = posts.id
+		WHERE $2 <= 0 OR ABS(pub_time - (SELECT pub_time FROM posts WHERE id = $1)) <= $2
+		ORDER BY related.shared DESC, pub_time DESC
+		LIMIT $3
+	`, postID, window, limit)
+	if err != nil {
+		return nil, fmt.Errorf("can't get related posts from db: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []storage.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("can't scan related post: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't get related posts from db: %w", err)
+	}
+	if len(posts) > 0 {
+		return posts, nil
+	}
+
+	// no related posts, tell an unknown post from one without them
+	var exists bool
+	err = db.pool.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1)
+	`, postID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("can't get post from db: %w", err)
+	}
+	if !exists {
+		return nil, storage.ErrNotFound
+	}
+	return posts, nil
+}
@@ -24,14 +24,10 @@ func TestMain(m *testing.M) {
 	}
 	defer testDB.pool.Close()
 
-	testDB.pool.Exec(context.Background(), "DROP TABLE IF EXISTS posts")
-	testDB.pool.Exec(context.Background(), `CREATE TABLE posts (
-		id SERIAL PRIMARY KEY,
-		title TEXT NOT NULL,
-		content TEXT NOT NULL,
-		pub_time INTEGER DEFAULT 0,
-		link TEXT NOT NULL UNIQUE
-	)`)
+	testDB.pool.Exec(context.Background(), "DROP TABLE IF EXISTS post_revisions, posts_tags, tags, posts, posts_archive, schema_migrations")
+	if _, err := testDB.Migrate(); err != nil {
+		panic(err)
+	}
 
 	m.Run()
 
@@ -54,8 +50,16 @@ func TestAddPosts(t *testing.T) {
 			Link:    strconv.Itoa(r.Intn(1_000_000)),
 		},
 	}
-	err := testDB.AddPosts(posts)
+	inserted, updated, err := testDB.AddPosts(posts)
 	assert.NoError(t, err, "Should be able to add posts without errors")
+	assert.Equal(t, 2, inserted, "Both posts should be inserted")
+	assert.Equal(t, 0, updated, "No posts should be updated")
+
+	posts[1].Content = "Updated content for test post 2"
+	inserted, updated, err = testDB.AddPosts(posts)
+	assert.NoError(t, err, "Should be able to upsert posts without errors")
+	assert.Equal(t, 0, inserted, "No posts should be inserted")
+	assert.Equal(t, 1, updated, "Only the changed post should be updated")
 }
 
 func TestGetPosts(t *testing.T) {
@@ -76,7 +80,7 @@ func TestPostByID(t *testing.T) {
 		Link:    strconv.Itoa(r.Intn(1_000_000)),
 	}
 
-	err := testDB.AddPosts([]storage.Post{newPost})
+	_, _, err := testDB.AddPosts([]storage.Post{newPost})
 	assert.NoError(t, err)
 
 	retrievedPost, err := testDB.PostByID(3)
@@ -102,10 +106,10 @@ func TestFilter(t *testing.T) {
 			Link:    strconv.Itoa(r.Intn(1_000_000)),
 		},
 	}
-	err := testDB.AddPosts(testPosts)
+	_, _, err := testDB.AddPosts(testPosts)
 	assert.NoError(t, err)
 
-	filteredPosts, err := testDB.Filter("Go", 0, 2)
+	filteredPosts, err := testDB.Filter(storage.Query{Search: "Go"}, 0, 2)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, filteredPosts, "Should retrieve posts matching the filter")
 }
@@ -116,3 +120,139 @@ func TestCountPosts(t *testing.T) {
 	assert.NoError(t, err, "Should be able to get count without errors")
 	assert.True(t, count >= 0, "Count should be non-negative")
 }
+
+func TestPostsByTag(t *testing.T) {
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	testPosts := []storage.Post{
+		{
+			Title:   "Tagged Post",
+			Content: "Content for tagged post",
+			PubTime: time.Now().Unix(),
+			Link:    strconv.Itoa(r.Intn(1_000_000)),
+			Tags:    []string{"go", "testing"},
+		},
+	}
+	_, _, err := testDB.AddPosts(testPosts)
+	assert.NoError(t, err)
+
+	posts, err := testDB.PostsByTag("testing", 0, 10)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, posts, "Should retrieve posts marked by the tag")
+	assert.Equal(t, []string{"go", "testing"}, posts[0].Tags)
+
+	count, err := testDB.CountOfTag("testing")
+	assert.NoError(t, err)
+	assert.Equal(t, len(posts), count)
+
+	tags, err := testDB.Tags()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tags, "Should retrieve at least one tag")
+}
+
+func TestKeywordEqualToCategory(t *testing.T) {
+
+	keyword := "kw" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	post := storage.Post{
+		Title:    "Keyword and category",
+		Content:  "Content with a keyword that is also a category",
+		PubTime:  time.Now().Unix(),
+		Link:     "kw-" + keyword,
+		Tags:     []string{keyword},
+		Keywords: []string{keyword, "other" + keyword},
+	}
+	_, _, err := testDB.AddPosts([]storage.Post{post})
+	assert.NoError(t, err)
+
+	posts, err := testDB.Filter(storage.Query{Keyword: keyword}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, posts, 1, "The keyword filter should match a keyword that is also a category") {
+		assert.Equal(t, []string{keyword}, posts[0].Tags)
+		assert.Equal(t, post.Keywords, posts[0].Keywords)
+	}
+
+	count, err := testDB.CountOfTag(keyword)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "The post should be counted once")
+}
+
+func TestApplyRetention(t *testing.T) {
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	testPosts := []storage.Post{
+		{
+			Title:   "Old Post",
+			Content: "Content for old post",
+			PubTime: 1000,
+			Link:    strconv.Itoa(r.Intn(1_000_000)),
+			Source:  "retention",
+		},
+		{
+			Title:   "Fresh Post",
+			Content: "Content for fresh post",
+			PubTime: time.Now().Unix(),
+			Link:    strconv.Itoa(r.Intn(1_000_000)),
+			Source:  "retention",
+		},
+	}
+	_, _, err := testDB.AddPosts(testPosts)
+	assert.NoError(t, err)
+
+	removed, err := testDB.ApplyRetention(storage.Retention{Before: 2000, Archive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed, "Only the old post should be removed")
+
+	var archived int
+	err = testDB.pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM posts_archive WHERE title = 'Old Post'`).Scan(&archived)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, archived, "The old post should be archived")
+}
+
+func TestRelated(t *testing.T) {
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now().Unix()
+
+	testPosts := []storage.Post{
+		{
+			Title:    "Related Post",
+			Content:  "Content for related post",
+			PubTime:  now,
+			Link:     strconv.Itoa(r.Intn(1_000_000)),
+			Tags:     []string{"related"},
+			Keywords: []string{"similarity"},
+		},
+		{
+			Title:    "Similar Post",
+			Content:  "Content for similar post",
+			PubTime:  now - 60,
+			Link:     strconv.Itoa(r.Intn(1_000_000)),
+			Tags:     []string{"related"},
+			Keywords: []string{"similarity"},
+		},
+		{
+			Title:   "Distant Post",
+			Content: "Content for distant post",
+			PubTime: now - 3600,
+			Link:    strconv.Itoa(r.Intn(1_000_000)),
+			Tags:    []string{"related"},
+		},
+	}
+	_, _, err := testDB.AddPosts(testPosts)
+	assert.NoError(t, err)
+
+	var id int
+	err = testDB.pool.QueryRow(context.Background(), `SELECT id FROM posts WHERE link = $1`, testPosts[0].Link).Scan(&id)
+	assert.NoError(t, err)
+
+	posts, err := testDB.Related(id, 600, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, posts, 1, "Only the post within the window should be related") {
+		assert.Equal(t, "Similar Post", posts[0].Title)
+	}
+
+	_, err = testDB.Related(1_000_000, 600, 10)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -1,20 +1,80 @@
 package storage
 
+import "errors"
+
+// ErrNotFound is returned when the requested post doesn't exist.
+var ErrNotFound = errors.New("post not found")
+
 // Post represents a single post
 type Post struct {
-	ID      int
-	Title   string
-	Content string
-	PubTime int64
-	Link    string
+	ID       int
+	Title    string
+	Content  string
+	Summary  string
+	PubTime  int64
+	Link     string
+	Source   string
+	Lang     string
+	Tags     []string
+	Keywords []string
+}
+
+// Tag represents a tag with the number of posts marked by it
+type Tag struct {
+	Name  string
+	Count int
+}
+
+// Query selects the posts returned by Filter.
+// Search matches titles and contents, Keyword matches extracted keywords,
+// Lang matches the detected language of posts.
+// Empty fields match all posts.
+type Query struct {
+	Search  string
+	Keyword string
+	Lang    string
+}
+
+// Revision is a previous version of a post, saved when the source changed it.
+// ChangedAt is the Unix time when the version was replaced by a newer one.
+type Revision struct {
+	ID        int
+	PostID    int
+	Title     string
+	Content   string
+	PubTime   int64
+	ChangedAt int64
+}
+
+// Retention describes which posts are removed by the retention job.
+// A post is removed if it was published before Before (a Unix time)
+// or if it is not among the KeepPerSource newest posts of its source.
+// Zero values disable the corresponding rule.
+// If Archive is set, removed posts are moved to the archive instead of being dropped.
+type Retention struct {
+	Before        int64
+	KeepPerSource int
+	Archive       bool
 }
 
-// Interface represents a storage
+// Interface represents a storage.
+// Modified returns the Unix time of the last change of the posts: a post added,
+// changed, including its tags, keywords, summary and language, or removed.
+// Each change moves it forward by at least a second, so that a change made within
+// the same second as the previous one still gets a later time.
 type Interface interface {
 	Posts(int, int) ([]Post, error)
 	PostByID(int) (Post, error)
-	AddPosts([]Post) error
-	Filter(string, int, int) ([]Post, error)
+	PostsByIDs([]int) ([]Post, error)
+	AddPosts([]Post) (int, int, error)
+	Filter(Query, int, int) ([]Post, error)
 	Count() (int, error)
-	CountOfFilter(string) (int, error)
+	CountOfFilter(Query) (int, error)
+	Tags() ([]Tag, error)
+	PostsByTag(string, int, int) ([]Post, error)
+	CountOfTag(string) (int, error)
+	ApplyRetention(Retention) (int, error)
+	Revisions(int) ([]Revision, error)
+	Related(int, int64, int) ([]Post, error)
+	Modified() (int64, error)
 }
@@ -0,0 +1,175 @@
+package summary
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/suxrobshukurov/gonews/pkg/keywords"
+	"github.com/suxrobshukurov/gonews/pkg/storage"
+)
+
+const (
+	// damping is the TextRank damping factor.
+	damping = 0.85
+	// iterations is the number of TextRank iterations, enough for a few dozen sentences to converge.
+	iterations = 30
+)
+
+// Summarizer builds extractive summaries of posts with TextRank:
+// sentences are ranked by their word overlap with the other sentences,
+// and the best ones are returned in their original order.
+type Summarizer struct {
+	sentences int
+	minLength int
+	maxLength int
+}
+
+// New creates a summarizer picking the given number of sentences.
+// Contents shorter than minLength letters are used as their own summary.
+// Summaries longer than maxLength letters are cut at a word boundary, see truncate.
+func New(sentences int, minLength int, maxLength int) *Summarizer {
+	return &Summarizer{sentences: sentences, minLength: minLength, maxLength: maxLength}
+}
+
+// Name returns the name of the pipeline stage
+func (s *Summarizer) Name() string { return "summary" }
+
+// Process sets the summaries of the posts.
+// It makes the summarizer a pipeline stage.
+func (s *Summarizer) Process(posts []storage.Post) ([]storage.Post, error) {
+	for i := range posts {
+		posts[i].Summary = s.Summarize(posts[i].Content)
+	}
+	return posts, nil
+}
+
+// Summarize returns the summary of the text, at most maxLength letters long.
+func (s *Summarizer) Summarize(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	sentences := Sentences(text)
+	if len([]rune(text)) < s.minLength || len(sentences) <= s.sentences {
+		return truncate(text, s.maxLength)
+	}
+
+	scores := rank(sentences)
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	best := order[:s.sentences]
+	sort.Ints(best)
+
+	picked := make([]string, 0, len(best))
+	for _, i := range best {
+		picked = append(picked, sentences[i])
+	}
+	return truncate(strings.Join(picked, " "), s.maxLength)
+}
+
+// truncate cuts the text to at most max letters at the last word boundary
+// and marks the cut with an ellipsis. A single word longer than max is cut inside.
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	// one letter is left for the ellipsis, the cut is inside a word unless a space follows it
+	cut := string(runes[:max-1])
+	if !unicode.IsSpace(runes[max-1]) {
+		if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// rank scores the sentences with TextRank over the graph of sentence similarities.
+func rank(sentences []string) []float64 {
+	n := len(sentences)
+	stems := make([]map[string]bool, n)
+	for i, s := range sentences {
+		stems[i] = make(map[string]bool)
+		for _, st := range keywords.Stems(s) {
+			stems[i][st] = true
+		}
+	}
+
+	weights := make([][]float64, n)
+	sums := make([]float64, n)
+	for i := range weights {
+		weights[i] = make([]float64, n)
+		for j := range weights[i] {
+			if i != j {
+				weights[i][j] = similarity(stems[i], stems[j])
+				sums[i] += weights[i][j]
+			}
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+	for it := 0; it < iterations; it++ {
+		next := make([]float64, n)
+		for i := range next {
+			var sum float64
+			for j := range scores {
+				if weights[j][i] > 0 {
+					sum += weights[j][i] / sums[j] * scores[j]
+				}
+			}
+			next[i] = 1 - damping + damping*sum
+		}
+		scores = next
+	}
+	return scores
+}
+
+// similarity is the number of common stems of two sentences
+// normalized by the logarithms of their lengths, as in the TextRank paper.
+func similarity(a, b map[string]bool) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	var common int
+	for st := range a {
+		if b[st] {
+			common++
+		}
+	}
+	return float64(common) / (math.Log(float64(len(a))) + math.Log(float64(len(b))))
+}
+
+// Sentences splits the text into sentences ending with '.', '!', '?' or '…'
+// followed by a space and a capital letter or a digit.
+func Sentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(".!?…", runes[i]) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(".!?…\"»)", runes[end]) {
+			end++
+		}
+		if end+1 < len(runes) && runes[end] == ' ' && (unicode.IsUpper(runes[end+1]) || unicode.IsDigit(runes[end+1])) {
+			sentences = append(sentences, strings.TrimSpace(string(runes[start:end])))
+			start = end + 1
+			i = end
+		}
+	}
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
@@ -0,0 +1,58 @@
+package summary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSentences(t *testing.T) {
+	text := `Вышел Go 1.22. В нём исправлена семантика циклов! Что ещё нового? Версия 1.22.1 выйдет позже... См. заметки "о релизе."`
+	want := []string{
+		"Вышел Go 1.22.",
+		"В нём исправлена семантика циклов!",
+		"Что ещё нового?",
+		"Версия 1.22.1 выйдет позже...",
+		`См. заметки "о релизе."`,
+	}
+	if got := Sentences(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Sentences() = %q, want %q", got, want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	text := strings.Join([]string{
+		"Go 1.22 changes the semantics of loop variables in for loops.",
+		"Each iteration of a for loop now creates a new loop variable.",
+		"The weather in Mountain View was sunny during the release.",
+		"The new loop variable semantics fix many bugs with goroutines capturing loop variables.",
+		"The team had pizza afterwards.",
+	}, " ")
+
+	s := New(2, 10, 1000)
+	got := s.Summarize(text)
+	want := "Go 1.22 changes the semantics of loop variables in for loops. " +
+		"The new loop variable semantics fix many bugs with goroutines capturing loop variables."
+	if got != want {
+		t.Errorf("Summarize() = %q, want %q", got, want)
+	}
+}
+
+func TestSummarizeShortText(t *testing.T) {
+	text := "Short   post. With two sentences."
+	if got := New(1, 100, 1000).Summarize(text); got != "Short post. With two sentences." {
+		t.Errorf("Summarize() = %q, want the whole text", got)
+	}
+}
+
+func TestSummarizeLongSentence(t *testing.T) {
+	// A text with too few sentences to pick from is used whole, but not beyond the limit.
+	text := "Go 1.22 changes the semantics of loop variables, so each iteration creates a new variable"
+	got := New(3, 10, 30).Summarize(text)
+	if want := "Go 1.22 changes the semantics…"; got != want {
+		t.Errorf("Summarize() = %q, want %q", got, want)
+	}
+	if got := truncate("Переменные", 5); got != "Пере…" {
+		t.Errorf("truncate() = %q, want the word cut inside", got)
+	}
+}
@@ -0,0 +1,54 @@
+package tags
+
+import (
+	"sort"
+	"strings"
+)
+
+// synonyms maps alternative spellings of a tag to its canonical name.
+// Keys and values are expected to be already lower-cased.
+var synonyms = map[string]string{
+	"golang":            "go",
+	"go lang":           "go",
+	"го":                "go",
+	"js":                "javascript",
+	"ts":                "typescript",
+	"k8s":               "kubernetes",
+	"postgres":          "postgresql",
+	"пострес":           "postgresql",
+	"ml":                "machine learning",
+	"машинное обучение": "machine learning",
+	"ai":                "artificial intelligence",
+	"ии":                "artificial intelligence",
+	"db":                "databases",
+	"базы данных":       "databases",
+	"*nix":              "linux",
+}
+
+// Normalize returns the canonical form of a tag.
+// It trims spaces, lower-cases the tag, collapses inner whitespace
+// and replaces known synonyms with their canonical name.
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if canonical, ok := synonyms[tag]; ok {
+		return canonical
+	}
+	return tag
+}
+
+// NormalizeAll normalizes a list of tags, drops empty ones and duplicates
+// and returns the result sorted by name.
+func NormalizeAll(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = Normalize(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,32 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "Go", want: "go"},
+		{tag: "  Golang ", want: "go"},
+		{tag: "Machine   Learning", want: "machine learning"},
+		{tag: "K8S", want: "kubernetes"},
+		{tag: "Разработка", want: "разработка"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.tag); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeAll(t *testing.T) {
+	got := NormalizeAll([]string{"Golang", "go", " ", "Linux", "*nix"})
+	want := []string{"go", "linux"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeAll() = %v, want %v", got, want)
+	}
+}